@@ -0,0 +1,142 @@
+package gitgo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// BlobReader returns a reader for the contents of a blob, along with its size.
+// Unlike Object, it does not read the entire blob into memory: loose blobs
+// and undeltified packed blobs are decompressed as they are read.
+// Blobs stored as deltas must be reconstructed in memory before they can be read.
+// If r.Verify is set, the contents are hashed as they are read, and the reader
+// returns a *CorruptObjectError at the end of the blob if they do not match its name.
+// The caller is responsible for closing the reader.
+func (r *Repository) BlobReader(name SHA) (io.ReadCloser, int64, error) {
+	err := r.load()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	filename := loosePath(r.Basedir.Name(), name)
+	rc, size, err := looseBlobReader(filename)
+	if !os.IsNotExist(err) {
+		if err == nil && r.Verify {
+			rc = verifyBlobReader(rc, size, &CorruptObjectError{Name: name, Path: filename, Offset: -1})
+		}
+		return rc, size, err
+	}
+
+	pack, offset, ok, err := r.findPacked(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("object not found: %s", name)
+	}
+	return pack.blobReader(name, offset, r.Verify)
+}
+
+// verifyBlobReader wraps a blob reader so that its contents are verified as they are read
+func verifyBlobReader(rc io.ReadCloser, size int64, corrupt *CorruptObjectError) io.ReadCloser {
+	return &blobReader{newVerifyingReader(rc, "blob", size, corrupt), []io.Closer{rc}}
+}
+
+func looseBlobReader(filename string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	rc := &blobReader{zr, []io.Closer{zr, f}}
+
+	resultType, resultSize, err := readObjectHeader(zr)
+	if err != nil {
+		rc.Close()
+		return nil, 0, err
+	}
+	if resultType != "blob" {
+		rc.Close()
+		return nil, 0, fmt.Errorf("object is not a blob: %s", resultType)
+	}
+	size, err := strconv.ParseInt(resultSize, 10, 64)
+	if err != nil {
+		rc.Close()
+		return nil, 0, err
+	}
+	return rc, size, nil
+}
+
+// blobReader returns a reader for a blob stored in the packfile at the given offset.
+// If verify is set, the contents are checked against the blob's name.
+func (p *packfile) blobReader(name SHA, offset int64, verify bool) (io.ReadCloser, int64, error) {
+	f, err := os.Open(p.path(".pack"))
+	if err != nil {
+		return nil, 0, err
+	}
+	obj, r, err := p.readEntry(f, offset)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+
+	if obj._type >= OBJ_OFS_DELTA {
+		// The delta has to be applied to the entire base object,
+		// so the result is held in memory
+		f.Close()
+		obj, err = p.object(name, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		if obj.PatchedType() != OBJ_BLOB {
+			return nil, 0, fmt.Errorf("object is not a blob: %s", obj.Type())
+		}
+		if verify {
+			if err := p.checkObject(obj); err != nil {
+				return nil, 0, err
+			}
+		}
+		return ioutil.NopCloser(bytes.NewReader(obj.PatchedData)), int64(len(obj.PatchedData)), nil
+	}
+
+	if obj._type != OBJ_BLOB {
+		f.Close()
+		return nil, 0, fmt.Errorf("object is not a blob: %s", obj.Type())
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	var rc io.ReadCloser = &blobReader{zr, []io.Closer{zr, f}}
+	if verify {
+		rc = verifyBlobReader(rc, int64(obj.Size), &CorruptObjectError{Name: name, Path: p.path(".pack"), Offset: offset})
+	}
+	return rc, int64(obj.Size), nil
+}
+
+// blobReader reads the contents of a blob
+// and closes the underlying files when it is closed
+type blobReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *blobReader) Close() error {
+	var err error
+	for _, c := range b.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package gitgo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_BlobReader(t *testing.T) {
+	inputs := []SHA{
+		// loose object
+		mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"),
+		// packed, stored whole
+		mustSHA("6b32b1ac731898894c403f6b621bdda167ab8d7c"),
+		// packed, stored as a delta with depth 2
+		mustSHA("c3b8133617bbdb72e237b0f163fade7fbf1f0c18"),
+	}
+	repo := Repository{Basedir: *RepoDir}
+	for _, input := range inputs {
+		obj, err := repo.Object(input)
+		if err != nil {
+			t.Errorf("%s: %s", input, err)
+			continue
+		}
+		expected := obj.(Blob).Contents
+
+		rc, size, err := repo.BlobReader(input)
+		if err != nil {
+			t.Errorf("%s: %s", input, err)
+			continue
+		}
+		contents, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Errorf("%s: %s", input, err)
+			continue
+		}
+		if size != int64(len(expected)) {
+			t.Errorf("%s: expected size %d and received %d", input, len(expected), size)
+		}
+		if !bytes.Equal(expected, contents) {
+			t.Errorf("%s: contents don't match:\n%q\n%q", input, expected, contents)
+		}
+	}
+}
+
+func Test_BlobReaderNotBlob(t *testing.T) {
+	repo := Repository{Basedir: *RepoDir}
+	// a loose tree and a packed commit
+	for _, input := range []SHA{mustSHA("1efecd717188441397c07f267cf468fdf04d4796"), mustSHA("1d833eb5b6c5369c0cb7a4a3e20ded237490145f")} {
+		_, _, err := repo.BlobReader(input)
+		if err == nil {
+			t.Errorf("expected an error reading %s as a blob", input)
+		}
+	}
+}
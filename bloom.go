@@ -0,0 +1,215 @@
+package gitgo
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// The settings for changed-path Bloom filters, which are the same as git's.
+// A commit that changes more than bloomMaxChangedPaths paths
+// is given a filter that matches every path.
+const (
+	bloomHashVersion     = 1
+	bloomNumHashes       = 7
+	bloomBitsPerEntry    = 10
+	bloomMaxChangedPaths = 512
+)
+
+// the seeds for the two murmur3 hashes that each Bloom filter key is derived from
+const (
+	bloomSeed0 = 0x293ae76f
+	bloomSeed1 = 0x7e646e2c
+)
+
+// murmur3 returns the 32-bit murmur3 hash of data with the given seed,
+// as computed by version 1 of git's changed-path Bloom filters.
+// That version reads each byte as a signed char, as C does on most platforms,
+// so bytes with the high bit set are sign-extended before they are combined.
+// This only affects paths that are not ASCII.
+func murmur3(seed uint32, data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+		m  = 5
+		n  = 0xe6546b64
+	)
+	char := func(b byte) uint32 {
+		return uint32(int32(int8(b)))
+	}
+
+	h := seed
+	blocks := len(data) / 4
+	for i := 0; i < blocks; i++ {
+		b := data[i*4:]
+		k := char(b[0]) | char(b[1])<<8 | char(b[2])<<16 | char(b[3])<<24
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*m + n
+	}
+
+	var k uint32
+	tail := data[blocks*4:]
+	switch len(tail) {
+	case 3:
+		k ^= char(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= char(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= char(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// newBloomFilter returns a changed-path Bloom filter containing the given paths.
+// Each byte holds eight bits, starting with the least significant.
+// If paths is nil, or has more than bloomMaxChangedPaths paths,
+// the filter has every bit set.
+func newBloomFilter(paths map[string]bool) []byte {
+	if paths == nil || len(paths) > bloomMaxChangedPaths {
+		return []byte{0xff}
+	}
+	size := (len(paths)*bloomBitsPerEntry + 7) / 8
+	if size == 0 {
+		size = 1
+	}
+	filter := make([]byte, size)
+	for path := range paths {
+		hash0 := murmur3(bloomSeed0, []byte(path))
+		hash1 := murmur3(bloomSeed1, []byte(path))
+		for i := uint32(0); i < bloomNumHashes; i++ {
+			bit := uint64(hash0+i*hash1) % uint64(size*8)
+			filter[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return filter
+}
+
+// changedPaths returns the paths that differ between the trees of a commit
+// and its first parent (or the empty tree, for a commit with no parents),
+// along with every directory that contains them, as git adds them to a commit's
+// Bloom filter. Renames are not detected. If more than bloomMaxChangedPaths files
+// have changed, it stops early and returns nil.
+func (r *Repository) changedPaths(node CommitNode) (map[string]bool, error) {
+	var parentTree SHA
+	if len(node.Parents) > 0 {
+		parent, err := r.CommitNode(node.Parents[0])
+		if err != nil {
+			return nil, err
+		}
+		parentTree = parent.Tree
+	}
+
+	var changed []string
+	if err := r.diffTrees(parentTree, node.Tree, "", &changed); err != nil {
+		return nil, err
+	}
+	if len(changed) > bloomMaxChangedPaths {
+		return nil, nil
+	}
+
+	paths := map[string]bool{}
+	for _, path := range changed {
+		for {
+			paths[path] = true
+			i := strings.LastIndexByte(path, '/')
+			if i < 0 {
+				break
+			}
+			path = path[:i]
+		}
+	}
+	return paths, nil
+}
+
+// diffTrees appends the path of every file that differs between two trees,
+// including files that are only in one of them, to changed.
+// Subtrees are compared recursively, so directories are not listed themselves.
+// The zero SHA is the empty tree.
+func (r *Repository) diffTrees(oldTree, newTree SHA, prefix string, changed *[]string) error {
+	if oldTree == newTree || len(*changed) > bloomMaxChangedPaths {
+		return nil
+	}
+	oldEntries, err := r.treeEntries(oldTree)
+	if err != nil {
+		return err
+	}
+	newEntries, err := r.treeEntries(newTree)
+	if err != nil {
+		return err
+	}
+
+	// A subtree and a file with the same name are different entries,
+	// so a file that is replaced with a directory is removed,
+	// and the files in the directory are added
+	type key struct {
+		name string
+		tree bool
+	}
+	oldByName := map[key]TreeEntry{}
+	for _, entry := range oldEntries {
+		oldByName[key{entry.Name, entry.Mode.IsTree()}] = entry
+	}
+	for _, entry := range newEntries {
+		k := key{entry.Name, entry.Mode.IsTree()}
+		oldEntry, ok := oldByName[k]
+		delete(oldByName, k)
+		if ok && oldEntry.Hash == entry.Hash && oldEntry.Mode == entry.Mode {
+			continue
+		}
+		if !k.tree {
+			*changed = append(*changed, prefix+entry.Name)
+			continue
+		}
+		var oldSubtree SHA
+		if ok {
+			oldSubtree = oldEntry.Hash
+		}
+		if err := r.diffTrees(oldSubtree, entry.Hash, prefix+entry.Name+"/", changed); err != nil {
+			return err
+		}
+	}
+	for k, entry := range oldByName {
+		if !k.tree {
+			*changed = append(*changed, prefix+entry.Name)
+			continue
+		}
+		if err := r.diffTrees(entry.Hash, SHA{}, prefix+entry.Name+"/", changed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeEntries returns the entries of the tree with the given name,
+// or no entries for the zero SHA
+func (r *Repository) treeEntries(name SHA) ([]TreeEntry, error) {
+	if name.IsZero() {
+		return nil, nil
+	}
+	obj, err := r.Object(name)
+	if err != nil {
+		return nil, err
+	}
+	tree, ok := obj.(Tree)
+	if !ok {
+		return nil, fmt.Errorf("%s is a %s, not a tree", name, obj.Type())
+	}
+	return tree.Entries, nil
+}
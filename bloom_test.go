@@ -0,0 +1,84 @@
+package gitgo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_murmur3(t *testing.T) {
+	cases := []struct {
+		seed     uint32
+		data     string
+		expected uint32
+	}{
+		{0, "", 0x00000000},
+		{0, "Hello world!", 0x627b0c2c},
+		{0, "The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	}
+	for _, tc := range cases {
+		if result := murmur3(tc.seed, []byte(tc.data)); result != tc.expected {
+			t.Errorf("%q: expected %#08x and received %#08x", tc.data, tc.expected, result)
+		}
+	}
+}
+
+func Test_changedPaths(t *testing.T) {
+	repo := &Repository{Basedir: *CommitGraphRepoDir}
+	cases := []struct {
+		commit   string
+		expected map[string]bool
+	}{
+		// The first commit adds a file
+		{"f80f42f6bff4ad348fdf7873d3d86869a573649c", map[string]bool{"a": true}},
+
+		// Every directory that contains a changed file is included
+		{"10b6a54970fa6257957aae6f359c636068e3ce85", map[string]bool{"héllo wörld": true, "d": true, "d/e": true, "d/e/f": true}},
+
+		// A file is replaced with a directory
+		{"091ca377ace8a1e3a00ff72e3b19cbd5a679717f", map[string]bool{"a": true, "a/inner": true}},
+
+		// Too many files are added
+		{"43eefd42791f875505ce35b2f0b04442a0d5fdb1", nil},
+
+		// A symlink is added
+		{"c6fd79491473c0bd854fb6235bf0e6d34d29d8cc", map[string]bool{"link": true}},
+	}
+	for _, tc := range cases {
+		node, err := repo.CommitNode(mustSHA(tc.commit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths, err := repo.changedPaths(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(paths, tc.expected) {
+			t.Errorf("%s: expected %v and received %v", tc.commit, tc.expected, paths)
+		}
+	}
+}
+
+func Test_newBloomFilter(t *testing.T) {
+	if filter := newBloomFilter(nil); !reflect.DeepEqual(filter, []byte{0xff}) {
+		t.Errorf("expected a filter that matches everything and received %v", filter)
+	}
+	if filter := newBloomFilter(map[string]bool{}); !reflect.DeepEqual(filter, []byte{0}) {
+		t.Errorf("expected an empty filter and received %v", filter)
+	}
+
+	// Each path sets up to seven bits, in ten bits per path, rounded up to a byte
+	paths := map[string]bool{"a": true, "b/c": true, "b": true}
+	filter := newBloomFilter(paths)
+	if len(filter) != 4 {
+		t.Errorf("expected a 4-byte filter and received %d bytes", len(filter))
+	}
+	var set int
+	for _, b := range filter {
+		for ; b > 0; b &= b - 1 {
+			set++
+		}
+	}
+	if set == 0 || set > 3*bloomNumHashes {
+		t.Errorf("unexpected number of bits set: %d", set)
+	}
+}
@@ -0,0 +1,93 @@
+package gitgo
+
+import "container/list"
+
+// DefaultDeltaBaseCacheLimit is the default size of a Repository's delta base cache,
+// in bytes, if neither Repository.DeltaBaseCacheLimit nor core.deltaBaseCacheLimit
+// is set. It is the same as git's default.
+const DefaultDeltaBaseCacheLimit = 96 << 20
+
+// CacheStats describes the contents and effectiveness of a Repository's delta base cache
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+
+	// Entries is the number of objects in the cache,
+	// and Size is the total size of their contents in bytes
+	Entries int
+	Size    int64
+}
+
+// deltaBaseCache holds the contents of objects that have been used
+// as delta bases, so that reading several objects that share part of a delta chain
+// only resolves the chain once. Once the objects in the cache exceed the limit,
+// the least recently used are evicted.
+type deltaBaseCache struct {
+	limit   int64
+	lru     *list.List
+	entries map[deltaBaseKey]*list.Element
+	stats   CacheStats
+}
+
+// deltaBaseKey identifies an object by its location,
+// since the names of OFS_DELTA bases are not known
+type deltaBaseKey struct {
+	pack   *packfile
+	offset int64
+}
+
+type deltaBaseEntry struct {
+	key   deltaBaseKey
+	_type packObjectType
+	data  []byte
+	depth int
+}
+
+// newDeltaBaseCache returns a cache that holds at most limit bytes.
+// If limit is not positive, nothing is cached, but misses are still counted.
+func newDeltaBaseCache(limit int64) *deltaBaseCache {
+	return &deltaBaseCache{limit: limit, lru: list.New(), entries: map[deltaBaseKey]*list.Element{}}
+}
+
+// get returns the resolved object at the given offset in the packfile, if it is cached.
+// The caller must not modify the object's contents.
+func (c *deltaBaseCache) get(pack *packfile, offset int64) (*deltaBaseEntry, bool) {
+	e, ok := c.entries[deltaBaseKey{pack, offset}]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(e)
+	return e.Value.(*deltaBaseEntry), true
+}
+
+// add caches a resolved object, evicting the least recently used objects
+// to make room for it. Objects larger than the limit are not cached.
+func (c *deltaBaseCache) add(pack *packfile, offset int64, _type packObjectType, data []byte, depth int) {
+	key := deltaBaseKey{pack, offset}
+	if _, ok := c.entries[key]; ok || int64(len(data)) > c.limit {
+		return
+	}
+	for c.stats.Size+int64(len(data)) > c.limit {
+		oldest := c.lru.Back()
+		entry := c.lru.Remove(oldest).(*deltaBaseEntry)
+		delete(c.entries, entry.key)
+		c.stats.Size -= int64(len(entry.data))
+		c.stats.Evictions++
+	}
+	c.entries[key] = c.lru.PushFront(&deltaBaseEntry{key: key, _type: _type, data: data, depth: depth})
+	c.stats.Size += int64(len(data))
+}
+
+// DeltaBaseCacheStats returns statistics about the cache of delta bases
+// that the repository uses while reading packed objects
+func (r *Repository) DeltaBaseCacheStats() CacheStats {
+	if r.cache == nil {
+		return CacheStats{}
+	}
+	stats := r.cache.stats
+	stats.Entries = len(r.cache.entries)
+	return stats
+}
@@ -0,0 +1,64 @@
+package gitgo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_deltaBaseCache(t *testing.T) {
+	c := newDeltaBaseCache(10)
+	pack := &packfile{}
+	for offset := int64(0); offset < 3; offset++ {
+		c.add(pack, offset, OBJ_BLOB, []byte("abcd"), 0)
+	}
+	// Too large to be cached
+	c.add(pack, 3, OBJ_BLOB, []byte("abcdefghijk"), 0)
+
+	if _, ok := c.get(pack, 0); ok {
+		t.Errorf("expected the least recently used object to be evicted")
+	}
+	if _, ok := c.get(pack, 3); ok {
+		t.Errorf("expected an object larger than the limit not to be cached")
+	}
+	entry, ok := c.get(pack, 1)
+	if !ok || string(entry.data) != "abcd" {
+		t.Fatalf("expected a cached object and received %v", entry)
+	}
+
+	// Offset 1 was used more recently than offset 2
+	c.add(pack, 4, OBJ_BLOB, []byte("abc"), 0)
+	if _, ok := c.get(pack, 2); ok {
+		t.Errorf("expected the least recently used object to be evicted")
+	}
+	expected := CacheStats{Hits: 1, Misses: 3, Evictions: 2, Size: 7}
+	if !reflect.DeepEqual(c.stats, expected) {
+		t.Errorf("expected %+v and received %+v", expected, c.stats)
+	}
+}
+
+func Test_DeltaBaseCacheStats(t *testing.T) {
+	// c3b8133 is a delta against 05d3cc7, which is a delta against 7147f43
+	name := mustSHA("c3b8133617bbdb72e237b0f163fade7fbf1f0c18")
+
+	repo := Repository{Basedir: *RepoDir}
+	for i := 0; i < 2; i++ {
+		if _, err := repo.Object(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first read caches both bases, and the second finds the first base in the cache
+	stats := repo.DeltaBaseCacheStats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Entries != 2 || stats.Size == 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	repo = Repository{Basedir: *RepoDir, DeltaBaseCacheLimit: -1}
+	for i := 0; i < 2; i++ {
+		if _, err := repo.Object(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := repo.DeltaBaseCacheStats(); stats.Hits != 0 || stats.Misses != 4 || stats.Entries != 0 {
+		t.Errorf("unexpected stats with the cache disabled: %+v", stats)
+	}
+}
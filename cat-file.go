@@ -8,24 +8,35 @@ import (
 
 type keyType string
 
-// SHA represents the SHA-1 hash used by git
-type SHA string
-
 const (
-	treeKey      keyType = "tree"
-	parentKey            = "parent"
-	authorKey            = "author"
-	committerKey         = "committer"
+	treeKey         keyType = "tree"
+	parentKey               = "parent"
+	authorKey               = "author"
+	committerKey            = "committer"
+	objectKey               = "object"
+	typeKey                 = "type"
+	tagKey                  = "tag"
+	taggerKey               = "tagger"
+	encodingKey             = "encoding"
+	gpgsigKey               = "gpgsig"
+	gpgsigSHA256Key         = "gpgsig-sha256"
+	mergetagKey             = "mergetag"
 )
 
 // CatFile implements git cat-file for the command-line
-// tool. Currently it supports only the -t fiag
-func CatFile(name SHA) (io.Reader, error) {
+// tool. Currently it supports only the -t fiag.
+// The name may be abbreviated.
+func CatFile(name string) (io.Reader, error) {
 	pwd, err := os.Open(".")
 	if err != nil {
 		return nil, err
 	}
-	obj, err := NewObject(name, *pwd)
+	repo := Repository{Basedir: *pwd}
+	sha, err := repo.ResolvePrefix(name)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := repo.Object(sha)
 	if err != nil {
 		return nil, err
 	}
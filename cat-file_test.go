@@ -2,6 +2,7 @@ package gitgo
 
 import (
 	"compress/zlib"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -43,22 +44,18 @@ func ReaderEqual(t *testing.T, r1 io.Reader, expected io.Reader) (err error) {
 }
 
 func Test_parseObjInitialCommit(t *testing.T) {
-	tm, err := time.Parse(RFC2822, "Fri Apr 3 11:45:00 2015 -0400")
-	if err != nil {
-		t.Fatal(err)
-	}
-	const inputSHA = SHA("97eed02ebe122df8fdd853c1215d8775f3d9f1a1")
+	const signature = "aditya <dev@chimeracoder.net> 1428075900 -0400"
+	tm := time.Unix(1428075900, 0).In(time.FixedZone("", -4*60*60))
+	inputSHA := mustSHA("97eed02ebe122df8fdd853c1215d8775f3d9f1a1")
 	expected := Commit{
-		_type:         "commit",
-		Name:          inputSHA,
-		Tree:          "9de6c72106b169990a83ce7090c7cad84b6b506b",
-		Parents:       nil,
-		Author:        "aditya <dev@chimeracoder.net>",
-		AuthorDate:    tm,
-		Committer:     "aditya <dev@chimeracoder.net>",
-		CommitterDate: tm,
-		Message:       []byte("First commit. Create .gitignore"),
-		size:          "190",
+		_type:     "commit",
+		Name:      inputSHA,
+		Tree:      mustSHA("9de6c72106b169990a83ce7090c7cad84b6b506b"),
+		Parents:   nil,
+		Author:    Signature{"aditya", "dev@chimeracoder.net", tm, "-0400", signature},
+		Committer: Signature{"aditya", "dev@chimeracoder.net", tm, "-0400", signature},
+		Message:   []byte("First commit. Create .gitignore"),
+		size:      "190",
 	}
 	const input = "commit 190\x00" + `tree 9de6c72106b169990a83ce7090c7cad84b6b506b
 author aditya <dev@chimeracoder.net> 1428075900 -0400
@@ -66,12 +63,7 @@ committer aditya <dev@chimeracoder.net> 1428075900 -0400
 
 First commit. Create .gitignore`
 
-	pwd, err := os.Open(".")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	result, err := parseObj(strings.NewReader(input), inputSHA, *pwd)
+	result, err := parseObj(strings.NewReader(input), inputSHA)
 	if err != nil {
 		t.Error(err)
 		return
@@ -83,32 +75,23 @@ First commit. Create .gitignore`
 }
 
 func Test_parseObjTreeCommit(t *testing.T) {
-	tm, err := time.Parse(RFC2822, "Mon Apr 6 15:51:36 2015 -0400")
-	if err != nil {
-		t.Fatal(err)
-	}
-	const inputSHA = SHA("3ead3116d0378089f5ce61086354aac43e736b01")
+	const signature = "aditya <dev@chimeracoder.net> 1428349896 -0400"
+	tm := time.Unix(1428349896, 0).In(time.FixedZone("", -4*60*60))
+	inputSHA := mustSHA("3ead3116d0378089f5ce61086354aac43e736b01")
 	const fileContents = "commit 243\x00tree d22fc8a57073fdecae2001d00aff921440d3aabd\nparent 1d833eb5b6c5369c0cb7a4a3e20ded237490145f\nauthor aditya <dev@chimeracoder.net> 1428349896 -0400\ncommitter aditya <dev@chimeracoder.net> 1428349896 -0400\n\nRemove extraneous logging statements\n"
 
 	expected := Commit{
-		_type:         "commit",
-		Name:          inputSHA,
-		Tree:          "d22fc8a57073fdecae2001d00aff921440d3aabd",
-		Parents:       []SHA{"1d833eb5b6c5369c0cb7a4a3e20ded237490145f"},
-		Author:        "aditya <dev@chimeracoder.net>",
-		AuthorDate:    tm,
-		Committer:     "aditya <dev@chimeracoder.net>",
-		CommitterDate: tm,
-		Message:       []byte("Remove extraneous logging statements\n"),
-		size:          "243",
-	}
-
-	pwd, err := os.Open(".")
-	if err != nil {
-		t.Fatal(err)
+		_type:     "commit",
+		Name:      inputSHA,
+		Tree:      mustSHA("d22fc8a57073fdecae2001d00aff921440d3aabd"),
+		Parents:   []SHA{mustSHA("1d833eb5b6c5369c0cb7a4a3e20ded237490145f")},
+		Author:    Signature{"aditya", "dev@chimeracoder.net", tm, "-0400", signature},
+		Committer: Signature{"aditya", "dev@chimeracoder.net", tm, "-0400", signature},
+		Message:   []byte("Remove extraneous logging statements\n"),
+		size:      "243",
 	}
 
-	result, err := parseObj(strings.NewReader(fileContents), inputSHA, *pwd)
+	result, err := parseObj(strings.NewReader(fileContents), inputSHA)
 	if err != nil {
 		t.Error(err)
 		return
@@ -119,18 +102,62 @@ func Test_parseObjTreeCommit(t *testing.T) {
 	}
 }
 
+func Test_parseObjSignedCommit(t *testing.T) {
+	inputSHA := mustSHA("3ead3116d0378089f5ce61086354aac43e736b01")
+	const fileContents = "commit 0\x00tree d22fc8a57073fdecae2001d00aff921440d3aabd\n" +
+		"parent 1d833eb5b6c5369c0cb7a4a3e20ded237490145f\n" +
+		"author aditya <dev@chimeracoder.net> 1428349896 -0400\n" +
+		"committer aditya <dev@chimeracoder.net> 1428349896 -0400\n" +
+		"encoding ISO-8859-1\n" +
+		"mergetag object 37213e7bb3c334a0f7708c7afcab5babb3f95434\n type commit\n tag 0.1\n tagger aditya <dev@chimeracoder.net> 1428612007 -0400\n \n First implementation of the cli\n" +
+		"x-custom first line\n second line\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n \n iQEzBAABCAAdFiEE\n =abcd\n -----END PGP SIGNATURE-----\n" +
+		"\nRemove extraneous logging statements\n\nWith a body\n"
+
+	result, err := parseObj(strings.NewReader(fileContents), inputSHA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := result.(Commit)
+
+	if commit.Encoding != "ISO-8859-1" {
+		t.Errorf("unexpected encoding: %q", commit.Encoding)
+	}
+	const expectedSig = "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n=abcd\n-----END PGP SIGNATURE-----\n"
+	if string(commit.GPGSig) != expectedSig {
+		t.Errorf("unexpected signature: %q", commit.GPGSig)
+	}
+	const expectedTag = "object 37213e7bb3c334a0f7708c7afcab5babb3f95434\ntype commit\ntag 0.1\ntagger aditya <dev@chimeracoder.net> 1428612007 -0400\n\nFirst implementation of the cli\n"
+	if len(commit.MergeTags) != 1 || string(commit.MergeTags[0]) != expectedTag {
+		t.Errorf("unexpected mergetags: %q", commit.MergeTags)
+	}
+	expectedHeaders := []ExtraHeader{{Key: "x-custom", Value: []byte("first line\nsecond line")}}
+	if !reflect.DeepEqual(expectedHeaders, commit.ExtraHeaders) {
+		t.Errorf("unexpected extra headers: %q", commit.ExtraHeaders)
+	}
+	if string(commit.Message) != "Remove extraneous logging statements\n\nWith a body\n" {
+		t.Errorf("unexpected message: %q", commit.Message)
+	}
+}
+
 func Test_ParseTree(t *testing.T) {
-	const inputSha = SHA("1efecd717188441397c07f267cf468fdf04d4796")
+	inputSha := mustSHA("1efecd717188441397c07f267cf468fdf04d4796")
 	expected := Tree{
 		_type: "tree",
 		size:  "156",
-		Blobs: []objectMeta{
-			objectMeta{SHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"), "100644", ".gitignore"},
-			objectMeta{SHA("f45d37d9add8f21eb84678f6d2c66377c4dd0c5e"), "100644", "cat-file.go"},
-			objectMeta{SHA("2c225b962d6666011c69ca5c2c67204959f8ba32"), "100644", "cat-file_test.go"},
+		Entries: []TreeEntry{
+			TreeEntry{".gitignore", ModeRegular, mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")},
+			TreeEntry{"cat-file.go", ModeRegular, mustSHA("f45d37d9add8f21eb84678f6d2c66377c4dd0c5e")},
+			TreeEntry{"cat-file_test.go", ModeRegular, mustSHA("2c225b962d6666011c69ca5c2c67204959f8ba32")},
+			TreeEntry{"examples", ModeTree, mustSHA("d564d0bc3dd917926892c55e3706cc116d5b165e")},
 		},
-		Trees: []objectMeta{
-			objectMeta{SHA("d564d0bc3dd917926892c55e3706cc116d5b165e"), "040000", "examples"},
+		Blobs: []TreeEntry{
+			TreeEntry{".gitignore", ModeRegular, mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")},
+			TreeEntry{"cat-file.go", ModeRegular, mustSHA("f45d37d9add8f21eb84678f6d2c66377c4dd0c5e")},
+			TreeEntry{"cat-file_test.go", ModeRegular, mustSHA("2c225b962d6666011c69ca5c2c67204959f8ba32")},
+		},
+		Trees: []TreeEntry{
+			TreeEntry{"examples", ModeTree, mustSHA("d564d0bc3dd917926892c55e3706cc116d5b165e")},
 		},
 	}
 	result, err := NewObject(inputSha, *RepoDir)
@@ -143,8 +170,66 @@ func Test_ParseTree(t *testing.T) {
 
 }
 
+func Test_ParseTreeModes(t *testing.T) {
+	hash := func(s string) string {
+		bts, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bts)
+	}
+	// none of these objects exist in the test repository,
+	// since the entries are classified without reading them
+	input := "40000 examples\x00" + hash("00000000000000000000000000000000000000e1") +
+		"100755 run me.sh\x00" + hash("00000000000000000000000000000000000000e2") +
+		"120000 link\x00" + hash("00000000000000000000000000000000000000e3") +
+		"160000 vendor\x00" + hash("0000000000000000000000000000000000c0ffee")
+
+	tree, err := parseTree(strings.NewReader(input), "", SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TreeEntry{
+		{"examples", ModeTree, mustSHA("00000000000000000000000000000000000000e1")},
+		{"run me.sh", ModeExecutable, mustSHA("00000000000000000000000000000000000000e2")},
+		{"link", ModeSymlink, mustSHA("00000000000000000000000000000000000000e3")},
+		{"vendor", ModeGitlink, mustSHA("0000000000000000000000000000000000c0ffee")},
+	}
+	if !reflect.DeepEqual(expected, tree.Entries) {
+		t.Errorf("Expected and result don't match:\n\n%+v\n\n%+v", expected, tree.Entries)
+	}
+	if len(tree.Blobs) != 2 || len(tree.Trees) != 1 {
+		t.Errorf("expected 2 blobs and 1 tree, received %+v and %+v", tree.Blobs, tree.Trees)
+	}
+	if !tree.Entries[3].Mode.IsSubmodule() || tree.Entries[3].Mode.IsBlob() {
+		t.Errorf("expected %s to be a submodule", tree.Entries[3].Name)
+	}
+	if ModeTree.String() != "040000" {
+		t.Errorf("expected mode 040000 and received %s", ModeTree)
+	}
+}
+
+func Test_TreeEntryObject(t *testing.T) {
+	repo := Repository{Basedir: *RepoDir}
+	obj, err := repo.Object(mustSHA("1efecd717188441397c07f267cf468fdf04d4796"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := obj.(Tree)
+	for _, entry := range tree.Entries {
+		child, err := entry.Object(&repo)
+		if err != nil {
+			t.Errorf("%s: %s", entry.Name, err)
+			continue
+		}
+		if entry.Mode.IsTree() != (child.Type() == "tree") {
+			t.Errorf("%s has mode %s but is a %s", entry.Name, entry.Mode, child.Type())
+		}
+	}
+}
+
 func Test_ParseBlob(t *testing.T) {
-	const inputSha = SHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")
+	inputSha := mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")
 	expected := Blob{
 		_type:    "blob",
 		size:     "18",
@@ -160,8 +245,63 @@ func Test_ParseBlob(t *testing.T) {
 
 }
 
+func Test_ParseTag(t *testing.T) {
+	const signature = "aditya <dev@chimeracoder.net> 1428612007 -0400"
+	tm := time.Unix(1428612007, 0).In(time.FixedZone("", -4*60*60))
+	inputSha := mustSHA("49bac2b0a923fe6481c7cc207837cf663748c1ed")
+	expected := Tag{
+		_type:      "tag",
+		Name:       inputSha,
+		Object:     mustSHA("37213e7bb3c334a0f7708c7afcab5babb3f95434"),
+		ObjectType: "commit",
+		Tag:        "0.1",
+		Tagger:     Signature{"aditya", "dev@chimeracoder.net", tm, "-0400", signature},
+		Message:    []byte("First implementation of the cli\n"),
+		size:       "155",
+	}
+	result, err := NewObject(inputSha, *RepoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected and result don't match:\n\n%+v\n\n%+v", expected, result)
+	}
+}
+
+func Test_ParseSignedTag(t *testing.T) {
+	const signature = "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n=abcd\n-----END PGP SIGNATURE-----\n"
+	const input = "object 37213e7bb3c334a0f7708c7afcab5babb3f95434\ntype commit\ntag 0.1\ntagger aditya <dev@chimeracoder.net> 1428612007 -0400\n\nFirst implementation of the cli\n" + signature
+
+	tag, err := parseTag(strings.NewReader(input), "", mustSHA("49bac2b0a923fe6481c7cc207837cf663748c1ed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(tag.Message) != "First implementation of the cli\n" {
+		t.Errorf("unexpected tag message: %q", tag.Message)
+	}
+	if string(tag.GPGSig) != signature {
+		t.Errorf("unexpected tag signature: %q", tag.GPGSig)
+	}
+}
+
+func Test_PeelTag(t *testing.T) {
+	inputSha := mustSHA("49bac2b0a923fe6481c7cc207837cf663748c1ed")
+	repo := Repository{Basedir: *RepoDir}
+	obj, err := repo.Object(inputSha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.PeelTag(obj.(Tag))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Name != mustSHA("37213e7bb3c334a0f7708c7afcab5babb3f95434") {
+		t.Errorf("peeled tag to the wrong commit: %s", commit.Name)
+	}
+}
+
 func Test_ParsePackfile(t *testing.T) {
-	const inputSha = SHA("c3b8133617bbdb72e237b0f163fade7fbf1f0c18")
+	inputSha := mustSHA("c3b8133617bbdb72e237b0f163fade7fbf1f0c18")
 	const expected = 2160
 
 	result, err := NewObject(inputSha, *RepoDir)
@@ -177,20 +317,32 @@ func Test_ParsePackfile(t *testing.T) {
 }
 
 func Test_ParsePrefix(t *testing.T) {
-	const inputSha = SHA("1efecd717188441397c07f267cf468fdf04d4796")
+	inputSha := mustSHA("1efecd717188441397c07f267cf468fdf04d4796")
 	expected := Tree{
 		_type: "tree",
 		size:  "156",
-		Blobs: []objectMeta{
-			objectMeta{SHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"), "100644", ".gitignore"},
-			objectMeta{SHA("f45d37d9add8f21eb84678f6d2c66377c4dd0c5e"), "100644", "cat-file.go"},
-			objectMeta{SHA("2c225b962d6666011c69ca5c2c67204959f8ba32"), "100644", "cat-file_test.go"},
+		Entries: []TreeEntry{
+			TreeEntry{".gitignore", ModeRegular, mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")},
+			TreeEntry{"cat-file.go", ModeRegular, mustSHA("f45d37d9add8f21eb84678f6d2c66377c4dd0c5e")},
+			TreeEntry{"cat-file_test.go", ModeRegular, mustSHA("2c225b962d6666011c69ca5c2c67204959f8ba32")},
+			TreeEntry{"examples", ModeTree, mustSHA("d564d0bc3dd917926892c55e3706cc116d5b165e")},
+		},
+		Blobs: []TreeEntry{
+			TreeEntry{".gitignore", ModeRegular, mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")},
+			TreeEntry{"cat-file.go", ModeRegular, mustSHA("f45d37d9add8f21eb84678f6d2c66377c4dd0c5e")},
+			TreeEntry{"cat-file_test.go", ModeRegular, mustSHA("2c225b962d6666011c69ca5c2c67204959f8ba32")},
 		},
-		Trees: []objectMeta{
-			objectMeta{SHA("d564d0bc3dd917926892c55e3706cc116d5b165e"), "040000", "examples"},
+		Trees: []TreeEntry{
+			TreeEntry{"examples", ModeTree, mustSHA("d564d0bc3dd917926892c55e3706cc116d5b165e")},
 		},
 	}
-	result, err := NewObject(inputSha[:15], *RepoDir)
+	repo := Repository{Basedir: *RepoDir}
+	name, err := repo.ResolvePrefix(inputSha.String()[:15])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	result, err := repo.Object(name)
 	if err != nil {
 		t.Error(err)
 		return
@@ -202,10 +354,14 @@ func Test_ParsePrefix(t *testing.T) {
 
 func Test_ParsePrefixPackfile(t *testing.T) {
 	// in the test directory, this is stored in a packfile
-	const inputSHA SHA = "b45377f6daf59a4cec9e8de64f5df1533a7994cd"
-	_, err := NewObject(inputSHA[:15], *RepoDir)
+	inputSHA := mustSHA("b45377f6daf59a4cec9e8de64f5df1533a7994cd")
+	repo := Repository{Basedir: *RepoDir}
+	name, err := repo.ResolvePrefix(inputSHA.String()[:15])
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	if name != inputSHA {
+		t.Errorf("expected %s and received %s", inputSHA, name)
 	}
 }
 
@@ -218,8 +374,8 @@ func Test_NakedDirectory(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	const inputSHA SHA = "254671773e8cd91e07e36546c9a2d9c27e8dfeec"
-	_, err = NewObject(inputSHA[:15], *dir)
+	inputSHA := mustSHA("254671773e8cd91e07e36546c9a2d9c27e8dfeec")
+	_, err = NewObject(inputSHA, *dir)
 	if err != nil {
 		t.Error(err)
 	}
@@ -233,8 +389,8 @@ func Test_Subdirectory(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	const inputSHA SHA = "254671773e8cd91e07e36546c9a2d9c27e8dfeec"
-	_, err = NewObject(inputSHA[:15], *dir)
+	inputSHA := mustSHA("254671773e8cd91e07e36546c9a2d9c27e8dfeec")
+	_, err = NewObject(inputSHA, *dir)
 	if err != nil {
 		t.Error(err)
 	}
@@ -244,7 +400,7 @@ func Test_Subdirectory(t *testing.T) {
 // including opening the current directory and searching for the location
 // of the closest parent git repository
 func BenchmarkCatFile(b *testing.B) {
-	const inputSha = SHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")
+	inputSha := "af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"
 	for i := 0; i < b.N; i++ {
 		_, _ = CatFile(inputSha) //, *RepoDir)
 	}
@@ -254,7 +410,7 @@ func BenchmarkCatFile(b *testing.B) {
 // and parses it into a blob GitObject. The benchmark includes the zlib inflate operation.
 // It uses an object that is not stored within a packfile.
 func BenchmarkParseObject(b *testing.B) {
-	const inputSha = SHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")
+	inputSha := mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")
 	basedir, err := os.Open(filepath.Join("test_data", ".git"))
 	if err != nil {
 		b.Error(err)
@@ -272,13 +428,13 @@ func BenchmarkParseObject(b *testing.B) {
 		if err != nil {
 			b.Fatalf("error on iteration %d: %s", i, err)
 		}
-		obj, err := parseObj(r, inputSha, *basedir)
+		obj, err := parseObj(r, inputSha)
 		if err != nil {
 			b.Fatalf("error on iteration %d: %s", i, err)
 		}
 		blb := obj.(Blob)
 		if blb.size != "18" {
-			b.Errorf("Expected size 18 and found size %d", blb.size)
+			b.Errorf("Expected size 18 and found size %s", blb.size)
 		}
 	}
 }
@@ -0,0 +1,83 @@
+package gitgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// readChunks reads the table of contents of a file in git's chunk format,
+// which is used by multi-pack-index and commit-graph files, and returns
+// the contents of each chunk by ID. The table starts at offset toc, and
+// has one 12-byte entry for each chunk: the chunk ID, followed by the offset
+// of the chunk within the file. An extra entry marks the end of the last chunk.
+// Every chunk must lie between the table and the trailing checksum.
+// kind is the type of file, which is used in error messages.
+func readChunks(data []byte, kind string, toc, numChunks, hashSize int) (map[string][]byte, error) {
+	if len(data) < toc+(numChunks+1)*12+hashSize {
+		return nil, fmt.Errorf("%s is too short for %d chunks", kind, numChunks)
+	}
+	first := uint64(toc + (numChunks+1)*12)
+	end := uint64(len(data) - hashSize)
+	chunks := map[string][]byte{}
+	for i := 0; i < numChunks; i++ {
+		entry := data[toc+i*12:]
+		id := string(entry[:4])
+		start := binary.BigEndian.Uint64(entry[4:12])
+		next := binary.BigEndian.Uint64(entry[16:24])
+		if start < first || start > next || next > end {
+			return nil, fmt.Errorf("invalid %s: chunk %q is out of range", kind, id)
+		}
+		chunks[id] = data[start:next]
+	}
+	return chunks, nil
+}
+
+// parseFanout checks that a fanout table has 256 entries and that they
+// never decrease, and returns the last entry, which is the number of objects
+func parseFanout(fanout []byte, kind string) (int, error) {
+	if len(fanout) != 256*4 {
+		return 0, fmt.Errorf("invalid %s: fanout table is %d bytes long", kind, len(fanout))
+	}
+	var previous uint32
+	for i := 0; i < 256; i++ {
+		n := binary.BigEndian.Uint32(fanout[i*4:])
+		if n < previous {
+			return 0, fmt.Errorf("invalid fanout table: entry %d is smaller than entry %d", i, i-1)
+		}
+		previous = n
+	}
+	return int(previous), nil
+}
+
+// fileChunk is a chunk in a file that is being written
+type fileChunk struct {
+	id   string
+	data []byte
+}
+
+// writeChunks writes a file in git's chunk format, as read by readChunks:
+// the header, followed by the table of contents and the chunks themselves.
+// The caller writes the trailing checksum.
+func writeChunks(w io.Writer, header []byte, chunks []fileChunk) error {
+	var buf bytes.Buffer
+	buf.Write(header)
+	offset := uint64(len(header) + (len(chunks)+1)*12)
+	for _, chunk := range chunks {
+		buf.WriteString(chunk.id)
+		binary.Write(&buf, binary.BigEndian, offset)
+		offset += uint64(len(chunk.data))
+	}
+	buf.Write(make([]byte, 4))
+	binary.Write(&buf, binary.BigEndian, offset)
+	if _, err := buf.WriteTo(w); err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if _, err := w.Write(chunk.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package gitgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+const (
+	// graphLevelMax is the highest topological level that can be stored in CDAT
+	graphLevelMax = 1<<30 - 1
+
+	// graphGenerationOffsetMax is the largest generation offset
+	// that can be stored in GDA2 rather than GDO2
+	graphGenerationOffsetMax = 1<<31 - 1
+)
+
+// A CommitGraphWriter writes a commit-graph file for commits in a repository.
+// It is equivalent to `git commit-graph write`, and writes the same file
+// that git does for the same commits, with corrected commit dates
+// as the generation numbers.
+type CommitGraphWriter struct {
+	// ChangedPaths adds a Bloom filter to the commit-graph for each commit,
+	// listing the paths that differ from its first parent,
+	// as `git commit-graph write --changed-paths` does.
+	// Computing them requires reading every tree that has changed.
+	ChangedPaths bool
+
+	repo  *Repository
+	names []SHA
+	added map[SHA]bool
+}
+
+// graphCommit is a commit that is being written to a commit-graph
+type graphCommit struct {
+	node       CommitNode
+	pos        uint32
+	parents    []*graphCommit
+	level      uint32
+	generation uint64
+	filter     []byte
+}
+
+// NewCommitGraphWriter returns a CommitGraphWriter that reads commits
+// from the given repository
+func NewCommitGraphWriter(repo *Repository) *CommitGraphWriter {
+	return &CommitGraphWriter{repo: repo, added: map[SHA]bool{}}
+}
+
+// Add adds commits to the commit-graph, along with all of their ancestors.
+// Commits that have already been added are ignored. The commits are not read
+// until Write is called.
+func (w *CommitGraphWriter) Add(names ...SHA) {
+	for _, name := range names {
+		if w.added[name] {
+			continue
+		}
+		w.added[name] = true
+		w.names = append(w.names, name)
+	}
+}
+
+// Write writes a commit-graph containing every commit that has been added,
+// and their ancestors, to out. It returns the checksum of the file,
+// which git uses to name the files in a split commit-graph chain.
+func (w *CommitGraphWriter) Write(out io.Writer) (SHA, error) {
+	err := w.repo.load()
+	if err != nil {
+		return SHA{}, err
+	}
+	format := w.repo.format
+
+	commits, err := w.readCommits()
+	if err != nil {
+		return SHA{}, err
+	}
+	sort.Slice(commits, func(i, j int) bool {
+		return bytes.Compare(commits[i].node.Name.Bytes(), commits[j].node.Name.Bytes()) < 0
+	})
+	for i, commit := range commits {
+		commit.pos = uint32(i)
+	}
+	computeGenerations(commits)
+	if w.ChangedPaths {
+		for _, commit := range commits {
+			paths, err := w.repo.changedPaths(commit.node)
+			if err != nil {
+				return SHA{}, err
+			}
+			commit.filter = newBloomFilter(paths)
+		}
+	}
+
+	var fanout, names, data, generations, overflows, edges bytes.Buffer
+	counts := make([]uint32, 256)
+	for _, commit := range commits {
+		counts[commit.node.Name.Bytes()[0]]++
+	}
+	var total uint32
+	for _, count := range counts {
+		total += count
+		binary.Write(&fanout, binary.BigEndian, total)
+	}
+
+	for _, commit := range commits {
+		names.Write(commit.node.Name.Bytes())
+		data.Write(commit.node.Tree.Bytes())
+
+		// An octopus merge lists its first parent in CDAT, and the rest in EDGE
+		parent1, parent2 := uint32(graphParentNone), uint32(graphParentNone)
+		if len(commit.parents) > 0 {
+			parent1 = commit.parents[0].pos
+		}
+		switch {
+		case len(commit.parents) == 2:
+			parent2 = commit.parents[1].pos
+		case len(commit.parents) > 2:
+			parent2 = graphExtraEdgesNeeded | uint32(edges.Len()/4)
+			for i, parent := range commit.parents[1:] {
+				edge := parent.pos
+				if i == len(commit.parents)-2 {
+					edge |= graphLastEdge
+				}
+				binary.Write(&edges, binary.BigEndian, edge)
+			}
+		}
+		commitTime := uint64(commit.node.CommitTime.Unix())
+		binary.Write(&data, binary.BigEndian, []uint32{parent1, parent2})
+		binary.Write(&data, binary.BigEndian, uint64(commit.level)<<34|commitTime&(1<<34-1))
+
+		offset := commit.generation - commitTime
+		if offset > graphGenerationOffsetMax {
+			binary.Write(&generations, binary.BigEndian, graphGenerationOffsetOverflow|uint32(overflows.Len()/8))
+			binary.Write(&overflows, binary.BigEndian, offset)
+		} else {
+			binary.Write(&generations, binary.BigEndian, uint32(offset))
+		}
+	}
+
+	chunks := []fileChunk{
+		{graphFanout, fanout.Bytes()},
+		{graphNames, names.Bytes()},
+		{graphData, data.Bytes()},
+		{graphGenerationData, generations.Bytes()},
+	}
+	if overflows.Len() > 0 {
+		chunks = append(chunks, fileChunk{graphGenerationOverflow, overflows.Bytes()})
+	}
+	if edges.Len() > 0 {
+		chunks = append(chunks, fileChunk{graphExtraEdges, edges.Bytes()})
+	}
+	if w.ChangedPaths {
+		var index, filters bytes.Buffer
+		binary.Write(&filters, binary.BigEndian, []uint32{bloomHashVersion, bloomNumHashes, bloomBitsPerEntry})
+		var end uint32
+		for _, commit := range commits {
+			end += uint32(len(commit.filter))
+			binary.Write(&index, binary.BigEndian, end)
+			filters.Write(commit.filter)
+		}
+		chunks = append(chunks, fileChunk{graphBloomIndexes, index.Bytes()}, fileChunk{graphBloomData, filters.Bytes()})
+	}
+
+	h := format.New()
+	header := append([]byte(nil), commitGraphMagic...)
+	header = append(header, 1, map[ObjectFormat]byte{SHA1: 1, SHA256: 2}[format], byte(len(chunks)), 0)
+	if err := writeChunks(io.MultiWriter(out, h), header, chunks); err != nil {
+		return SHA{}, err
+	}
+	checksum := h.Sum(nil)
+	if _, err := out.Write(checksum); err != nil {
+		return SHA{}, err
+	}
+	return newSHA(checksum), nil
+}
+
+// readCommits reads every commit that has been added, and all of their ancestors
+func (w *CommitGraphWriter) readCommits() ([]*graphCommit, error) {
+	byName := map[SHA]*graphCommit{}
+	var commits []*graphCommit
+	pending := append([]SHA(nil), w.names...)
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if byName[name] != nil {
+			continue
+		}
+		node, err := w.repo.CommitNode(name)
+		if err != nil {
+			return nil, err
+		}
+		commit := &graphCommit{node: node}
+		byName[name] = commit
+		commits = append(commits, commit)
+		pending = append(pending, node.Parents...)
+	}
+
+	for _, commit := range commits {
+		for _, parent := range commit.node.Parents {
+			commit.parents = append(commit.parents, byName[parent])
+		}
+	}
+	return commits, nil
+}
+
+// computeGenerations computes the topological level and corrected commit date
+// of each commit, once those of its parents are known. The corrected commit date
+// is the later of the commit time and one more than the corrected commit dates
+// of its parents. A commit's ancestors are visited using a stack,
+// since the history may be very deep.
+func computeGenerations(commits []*graphCommit) {
+	done := map[*graphCommit]bool{}
+	for _, commit := range commits {
+		stack := []*graphCommit{commit}
+		for len(stack) > 0 {
+			current := stack[len(stack)-1]
+			if done[current] {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+
+			ready := true
+			var level uint32
+			var generation uint64
+			for _, parent := range current.parents {
+				if !done[parent] {
+					ready = false
+					stack = append(stack, parent)
+					continue
+				}
+				if parent.level > level {
+					level = parent.level
+				}
+				if parent.generation > generation {
+					generation = parent.generation
+				}
+			}
+			if !ready {
+				continue
+			}
+
+			stack = stack[:len(stack)-1]
+			done[current] = true
+			if level < graphLevelMax {
+				level++
+			}
+			current.level = level
+			current.generation = generation + 1
+			if commitTime := uint64(current.node.CommitTime.Unix()); commitTime > generation {
+				current.generation = commitTime
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package gitgo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_CommitGraphWriter(t *testing.T) {
+	// The expected files were written by git 2.39:
+	// git commit-graph write --reachable [--changed-paths]
+	cases := []struct {
+		dir          *os.File
+		tip          string
+		changedPaths bool
+		expected     string
+	}{
+		{CommitGraphRepoDir, "c6fd79491473c0bd854fb6235bf0e6d34d29d8cc", false, filepath.Join("test_data", "commit-graph", "commit-graph")},
+		{CommitGraphRepoDir, "c6fd79491473c0bd854fb6235bf0e6d34d29d8cc", true, filepath.Join("test_data", "commit-graph", "commit-graph-changed-paths")},
+		{SHA256RepoDir, "5e47833487fbadbc14672de6c7e4b79e0f79a3bfa51c8d4307b9efaba5fdee54", false, filepath.Join("test_data", "sha256", "dot_git", "objects", "info", "commit-graph")},
+	}
+	for _, tc := range cases {
+		expected, err := ioutil.ReadFile(tc.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := NewCommitGraphWriter(&Repository{Basedir: *tc.dir})
+		w.ChangedPaths = tc.changedPaths
+		w.Add(mustSHA(tc.tip), mustSHA(tc.tip))
+		var buf bytes.Buffer
+		checksum, err := w.Write(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("%s: commit-graph does not match the one written by git", tc.expected)
+		}
+		if !bytes.Equal(checksum.Bytes(), expected[len(expected)-len(checksum.Bytes()):]) {
+			t.Errorf("%s: expected the checksum of the file and received %s", tc.expected, checksum)
+		}
+	}
+}
+
+func Test_CommitGraphWriterOctopus(t *testing.T) {
+	repo := &Repository{Basedir: *CommitGraphRepoDir}
+	w := NewCommitGraphWriter(repo)
+	w.Add(mustSHA("c6fd79491473c0bd854fb6235bf0e6d34d29d8cc"))
+	var buf bytes.Buffer
+	if _, err := w.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	layer, _, err := parseCommitGraph(buf.Bytes(), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph := newCommitGraph(SHA1, []*commitGraphLayer{layer})
+	if graph.count != 7 {
+		t.Errorf("expected 7 commits and received %d", graph.count)
+	}
+
+	// Each commit in the graph matches the commit itself
+	for pos := 0; pos < graph.count; pos++ {
+		node, err := graph.node(pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := repo.CommitNode(node.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Tree != expected.Tree || !reflect.DeepEqual(node.Parents, expected.Parents) || !node.CommitTime.Equal(expected.CommitTime) {
+			t.Errorf("expected %+v and received %+v", expected, node)
+		}
+	}
+
+	pos, _ := graph.find(mustSHA("e025f60170df5c2ed4caa057696a80c122411144"))
+	octopus, err := graph.node(pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(octopus.Parents) != 3 || octopus.Level != 3 || octopus.Generation != uint64(octopus.CommitTime.Unix()) {
+		t.Errorf("unexpected octopus merge: %+v", octopus)
+	}
+
+	// The commit after the merge is dated 1970, so its generation is stored in GDO2
+	pos, _ = graph.find(mustSHA("4bdde50f0f338c043342fa6d6d30235e13b0c329"))
+	old, err := graph.node(pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Generation != octopus.Generation+1 || len(layer.generationOverflows) != 16 {
+		t.Errorf("expected generation %d and received %d", octopus.Generation+1, old.Generation)
+	}
+}
+
+func Test_CommitGraphWriterChain(t *testing.T) {
+	// The commits are read from the existing commit-graph chain,
+	// and written to a single file
+	repo := &Repository{Basedir: *RepoDir}
+	tip := mustSHA("37213e7bb3c334a0f7708c7afcab5babb3f95434")
+	history, err := repo.History(tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewCommitGraphWriter(repo)
+	w.Add(tip)
+	var buf bytes.Buffer
+	if _, err := w.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	layer, _, err := parseCommitGraph(buf.Bytes(), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph := newCommitGraph(SHA1, []*commitGraphLayer{layer})
+	for _, expected := range history {
+		pos, ok := graph.find(expected.Name)
+		if !ok {
+			t.Fatalf("%s is not in the commit-graph", expected.Name)
+		}
+		node, err := graph.node(pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(node, expected) {
+			t.Errorf("expected %+v and received %+v", expected, node)
+		}
+	}
+}
+
+func Test_CommitGraphWriterNotCommit(t *testing.T) {
+	repo := &Repository{Basedir: *CommitGraphRepoDir}
+	node, err := repo.CommitNode(mustSHA("f80f42f6bff4ad348fdf7873d3d86869a573649c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewCommitGraphWriter(repo)
+	w.Add(node.Tree)
+	if _, err := w.Write(ioutil.Discard); err == nil {
+		t.Errorf("expected an error for a tree")
+	}
+}
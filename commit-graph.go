@@ -0,0 +1,430 @@
+package gitgo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// commitGraphMagic is the first four bytes of a commit-graph file
+var commitGraphMagic = []byte("CGPH")
+
+// commit-graph chunk IDs
+const (
+	graphFanout             = "OIDF"
+	graphNames              = "OIDL"
+	graphData               = "CDAT"
+	graphGenerationData     = "GDA2"
+	graphGenerationOverflow = "GDO2"
+	graphExtraEdges         = "EDGE"
+	graphBaseGraphs         = "BASE"
+	graphBloomIndexes       = "BIDX"
+	graphBloomData          = "BDAT"
+)
+
+const (
+	// graphParentNone marks a missing parent in CDAT
+	graphParentNone = 0x70000000
+
+	// graphExtraEdgesNeeded is set on the second parent in CDAT if the commit
+	// has more than two parents, and graphLastEdge is set on the last parent in EDGE
+	graphExtraEdgesNeeded = 0x80000000
+	graphLastEdge         = 0x80000000
+
+	// graphGenerationOffsetOverflow is set on an offset in GDA2 that is stored in GDO2
+	graphGenerationOffsetOverflow = 0x80000000
+)
+
+// A CommitNode describes a commit's place in the history of the repository,
+// without its author, committer, or message. This is the information
+// that is stored for each commit in the commit-graph, so it can be read
+// without parsing the commit itself.
+type CommitNode struct {
+	Name    SHA
+	Tree    SHA
+	Parents []SHA
+
+	// CommitTime is the time in the commit's committer line, in UTC,
+	// since the commit-graph does not record the time zone
+	CommitTime time.Time
+
+	// Level is the commit's topological level: 1 for a commit with no parents,
+	// and otherwise one more than the highest level of its parents.
+	// Generation is the commit's corrected commit date, which is at least
+	// its commit time and greater than the generation of each of its parents,
+	// if the commit-graph records it, and otherwise the same as Level.
+	// Either way, a commit cannot be an ancestor of a commit with a lower generation.
+	// Both are zero if the commit is not in the commit-graph.
+	Level      uint32
+	Generation uint64
+}
+
+// commitGraph is the contents of a commit-graph file, or a chain of them,
+// which `git commit-graph write` writes. It lists the parents, root tree,
+// commit time, and generation of each commit, so that history can be
+// walked without reading commit objects.
+//
+// Each file is laid out as:
+//
+//	header (8 bytes): magic number, version, hash version,
+//	  number of chunks, and number of base graphs
+//	table of contents: the ID and offset of each chunk, ending with a zero ID
+//	chunks, including:
+//	  OIDF: fanout table (256 4-byte entries)
+//	  OIDL: commit names (count entries of 20 or 32 bytes)
+//	  CDAT: the tree, first two parents, level, and commit time of each commit
+//	  GDA2: the offset from each commit time to the commit's corrected commit date
+//	  GDO2: offsets that do not fit in 31 bits
+//	  EDGE: the remaining parents of octopus merges
+//	  BIDX: the end of each commit's changed-path Bloom filter in BDAT
+//	  BDAT: the Bloom filter settings, followed by the filters
+//	  BASE: the names of the base graphs, for a graph in a chain
+//	checksum of the file
+//
+// A split commit-graph is a chain of files, listed in
+// objects/info/commit-graphs/commit-graph-chain. Each file adds commits
+// to the ones before it, and commits are numbered across the whole chain,
+// starting with the commits in the first file.
+type commitGraph struct {
+	format ObjectFormat
+	layers []*commitGraphLayer
+	count  int
+
+	// generationData is set if every layer records corrected commit dates.
+	// Otherwise, the topological level is used as the generation.
+	generationData bool
+}
+
+// commitGraphLayer is a single commit-graph file
+type commitGraphLayer struct {
+	// start is the position of the first commit in the layer
+	// within the whole chain
+	start int
+	count int
+
+	fanout              []byte
+	names               []byte
+	data                []byte
+	generations         []byte
+	generationOverflows []byte
+	extraEdges          []byte
+}
+
+// readCommitGraph reads objects/info/commit-graph, or the chain of split
+// commit-graph files if that does not exist. If the repository has neither,
+// it returns nil.
+func (r *Repository) readCommitGraph() (*commitGraph, error) {
+	infoDir := filepath.Join(r.Basedir.Name(), "objects", "info")
+	data, err := ioutil.ReadFile(filepath.Join(infoDir, "commit-graph"))
+	if err == nil {
+		layer, _, err := parseCommitGraph(data, r.format)
+		if err != nil {
+			return nil, err
+		}
+		return newCommitGraph(r.format, []*commitGraphLayer{layer}), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	graphsDir := filepath.Join(infoDir, "commit-graphs")
+	chain, err := ioutil.ReadFile(filepath.Join(graphsDir, "commit-graph-chain"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The chain lists the checksum of each file, starting with the base
+	var layers []*commitGraphLayer
+	var previous []SHA
+	scnr := bufio.NewScanner(bytes.NewReader(chain))
+	for scnr.Scan() {
+		checksum, err := ParseSHA(scnr.Text())
+		if err != nil || checksum.Format() != r.format {
+			return nil, fmt.Errorf("invalid commit-graph chain: %q", scnr.Text())
+		}
+		data, err := ioutil.ReadFile(filepath.Join(graphsDir, "graph-"+checksum.String()+".graph"))
+		if err != nil {
+			return nil, err
+		}
+		layer, bases, err := parseCommitGraph(data, r.format)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(data[len(data)-r.format.Size():], checksum.Bytes()) {
+			return nil, fmt.Errorf("commit-graph %s does not match its checksum", checksum)
+		}
+		if !shasEqual(bases, previous) {
+			return nil, fmt.Errorf("commit-graph %s has the wrong base graphs", checksum)
+		}
+		layers = append(layers, layer)
+		previous = append(previous, checksum)
+	}
+	if err := scnr.Err(); err != nil {
+		return nil, err
+	}
+	if len(layers) == 0 {
+		return nil, nil
+	}
+	return newCommitGraph(r.format, layers), nil
+}
+
+// shasEqual reports whether two lists of object names are the same
+func shasEqual(a, b []SHA) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newCommitGraph numbers the commits across a chain of layers, starting with the base
+func newCommitGraph(format ObjectFormat, layers []*commitGraphLayer) *commitGraph {
+	g := &commitGraph{format: format, layers: layers, generationData: true}
+	for _, layer := range layers {
+		layer.start = g.count
+		g.count += layer.count
+		if layer.generations == nil {
+			g.generationData = false
+		}
+	}
+	return g
+}
+
+// parseCommitGraph parses the contents of a single commit-graph file,
+// checking that each of the required chunks is present, and returns it
+// along with the names of its base graphs
+func parseCommitGraph(data []byte, format ObjectFormat) (*commitGraphLayer, []SHA, error) {
+	hashSize := format.Size()
+	if len(data) < 8+hashSize {
+		return nil, nil, fmt.Errorf("commit-graph is too short: %d bytes", len(data))
+	}
+	if !bytes.Equal(data[:4], commitGraphMagic) {
+		return nil, nil, fmt.Errorf("invalid commit-graph signature: %q", data[:4])
+	}
+	if version := data[4]; version != 1 {
+		return nil, nil, fmt.Errorf("cannot parse commit-graph with version %d", version)
+	}
+	hashVersion := map[ObjectFormat]byte{SHA1: 1, SHA256: 2}[format]
+	if data[5] != hashVersion {
+		return nil, nil, fmt.Errorf("commit-graph uses hash version %d, but the repository uses %s", data[5], format)
+	}
+	numChunks := int(data[6])
+	numBases := int(data[7])
+
+	chunks, err := readChunks(data, "commit-graph", 8, numChunks, hashSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	required := []string{graphFanout, graphNames, graphData}
+	if numBases > 0 {
+		required = append(required, graphBaseGraphs)
+	}
+	for _, id := range required {
+		if _, ok := chunks[id]; !ok {
+			return nil, nil, fmt.Errorf("invalid commit-graph: missing chunk %q", id)
+		}
+	}
+
+	layer := &commitGraphLayer{
+		fanout:              chunks[graphFanout],
+		names:               chunks[graphNames],
+		data:                chunks[graphData],
+		generations:         chunks[graphGenerationData],
+		generationOverflows: chunks[graphGenerationOverflow],
+		extraEdges:          chunks[graphExtraEdges],
+	}
+	layer.count, err = parseFanout(layer.fanout, "commit-graph")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(layer.names) != layer.count*hashSize || len(layer.data) != layer.count*(hashSize+16) {
+		return nil, nil, fmt.Errorf("invalid commit-graph: tables are the wrong size for %d commits", layer.count)
+	}
+	if layer.generations != nil && len(layer.generations) != layer.count*4 {
+		return nil, nil, fmt.Errorf("invalid commit-graph: generation data is %d bytes long", len(layer.generations))
+	}
+	if len(layer.generationOverflows)%8 != 0 || len(layer.extraEdges)%4 != 0 {
+		return nil, nil, fmt.Errorf("invalid commit-graph: overflow tables are the wrong size")
+	}
+
+	base := chunks[graphBaseGraphs]
+	if len(base) != numBases*hashSize {
+		return nil, nil, fmt.Errorf("invalid commit-graph: expected %d base graphs", numBases)
+	}
+	bases := make([]SHA, numBases)
+	for i := range bases {
+		bases[i] = newSHA(base[i*hashSize : (i+1)*hashSize])
+	}
+	return layer, bases, nil
+}
+
+// find returns the position of the commit with the given name
+// within the whole chain. Later layers are searched first.
+func (g *commitGraph) find(name SHA) (int, bool) {
+	target := name.Bytes()
+	size := g.format.Size()
+	if len(target) != size {
+		return 0, false
+	}
+	for i := len(g.layers) - 1; i >= 0; i-- {
+		layer := g.layers[i]
+		start := 0
+		if target[0] > 0 {
+			start = int(binary.BigEndian.Uint32(layer.fanout[(int(target[0])-1)*4:]))
+		}
+		end := int(binary.BigEndian.Uint32(layer.fanout[int(target[0])*4:]))
+		j := start + sort.Search(end-start, func(j int) bool {
+			return bytes.Compare(layer.names[(start+j)*size:(start+j+1)*size], target) >= 0
+		})
+		if j < end && bytes.Equal(layer.names[j*size:(j+1)*size], target) {
+			return layer.start + j, true
+		}
+	}
+	return 0, false
+}
+
+// layer returns the layer that contains the commit at the given position,
+// and the position of the commit within that layer
+func (g *commitGraph) layer(pos int) (*commitGraphLayer, int) {
+	i := sort.Search(len(g.layers), func(i int) bool {
+		return g.layers[i].start+g.layers[i].count > pos
+	})
+	return g.layers[i], pos - g.layers[i].start
+}
+
+// name returns the name of the commit at the given position
+func (g *commitGraph) name(pos int) (SHA, error) {
+	if pos < 0 || pos >= g.count {
+		return SHA{}, fmt.Errorf("invalid commit-graph: commit %d is out of range", pos)
+	}
+	layer, i := g.layer(pos)
+	size := g.format.Size()
+	return newSHA(layer.names[i*size : (i+1)*size]), nil
+}
+
+// node returns the commit at the given position
+func (g *commitGraph) node(pos int) (CommitNode, error) {
+	name, err := g.name(pos)
+	if err != nil {
+		return CommitNode{}, err
+	}
+	layer, i := g.layer(pos)
+	size := g.format.Size()
+
+	// Each entry is the root tree, the positions of the first two parents,
+	// and the level and commit time: 30 bits and 34 bits, respectively
+	entry := layer.data[i*(size+16) : (i+1)*(size+16)]
+	node := CommitNode{Name: name, Tree: newSHA(entry[:size])}
+	parent1 := binary.BigEndian.Uint32(entry[size:])
+	parent2 := binary.BigEndian.Uint32(entry[size+4:])
+	levelAndTime := binary.BigEndian.Uint64(entry[size+8:])
+	node.Level = uint32(levelAndTime >> 34)
+	commitTime := int64(levelAndTime & (1<<34 - 1))
+	node.CommitTime = time.Unix(commitTime, 0).UTC()
+
+	var parents []uint32
+	if parent1 != graphParentNone {
+		parents = append(parents, parent1)
+	}
+	switch {
+	case parent2 == graphParentNone:
+	case parent2&graphExtraEdgesNeeded == 0:
+		parents = append(parents, parent2)
+	default:
+		// An octopus merge: the remaining parents are listed in EDGE,
+		// and the last one is marked
+		for e := int(parent2 &^ graphExtraEdgesNeeded); ; e++ {
+			if e >= len(layer.extraEdges)/4 {
+				return CommitNode{}, fmt.Errorf("invalid commit-graph: parents of %s are out of range", name)
+			}
+			edge := binary.BigEndian.Uint32(layer.extraEdges[e*4:])
+			parents = append(parents, edge&^graphLastEdge)
+			if edge&graphLastEdge != 0 {
+				break
+			}
+		}
+	}
+	for _, parent := range parents {
+		// A commit's parents are in the same layer or an earlier one
+		if int64(parent) >= int64(layer.start+layer.count) {
+			return CommitNode{}, fmt.Errorf("invalid commit-graph: parent %d of %s is out of range", parent, name)
+		}
+		parentName, err := g.name(int(parent))
+		if err != nil {
+			return CommitNode{}, err
+		}
+
+		// A commit's parents have lower levels, unless the levels are too high
+		// to store, so a damaged commit-graph cannot make a commit its own ancestor
+		if level := g.level(int(parent)); level >= node.Level && level < graphLevelMax {
+			return CommitNode{}, fmt.Errorf("invalid commit-graph: parent %s of %s has level %d, which is not lower than %d", parentName, name, level, node.Level)
+		}
+		node.Parents = append(node.Parents, parentName)
+	}
+
+	node.Generation = uint64(node.Level)
+	if g.generationData {
+		offset := uint64(binary.BigEndian.Uint32(layer.generations[i*4:]))
+		if offset&graphGenerationOffsetOverflow != 0 {
+			o := int(offset &^ graphGenerationOffsetOverflow)
+			if o >= len(layer.generationOverflows)/8 {
+				return CommitNode{}, fmt.Errorf("invalid commit-graph: generation of %s is out of range", name)
+			}
+			offset = binary.BigEndian.Uint64(layer.generationOverflows[o*8:])
+		}
+		node.Generation = uint64(commitTime) + offset
+	}
+	return node, nil
+}
+
+// level returns the topological level of the commit at the given position,
+// which must be in range
+func (g *commitGraph) level(pos int) uint32 {
+	layer, i := g.layer(pos)
+	size := g.format.Size()
+	return binary.BigEndian.Uint32(layer.data[i*(size+16)+size+8:]) >> 2
+}
+
+// CommitNode returns the parents, root tree, commit time, and generation
+// of the commit with the given name. If the repository has a commit-graph
+// that contains the commit, they are read from it; otherwise, the commit
+// itself is read, and its level and generation are zero.
+func (r *Repository) CommitNode(name SHA) (CommitNode, error) {
+	err := r.load()
+	if err != nil {
+		return CommitNode{}, err
+	}
+	if r.graph != nil {
+		if pos, ok := r.graph.find(name); ok {
+			return r.graph.node(pos)
+		}
+	}
+
+	obj, err := r.Object(name)
+	if err != nil {
+		return CommitNode{}, err
+	}
+	commit, ok := obj.(Commit)
+	if !ok {
+		return CommitNode{}, fmt.Errorf("%s is a %s, not a commit", name, obj.Type())
+	}
+	return CommitNode{
+		Name:       name,
+		Tree:       commit.Tree,
+		Parents:    commit.Parents,
+		CommitTime: commit.Committer.When.UTC(),
+	}, nil
+}
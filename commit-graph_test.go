@@ -0,0 +1,290 @@
+package gitgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// checkCommitNodes compares each CommitNode to the commit that it describes,
+// and checks that the levels and generations of its parents are lower
+func checkCommitNodes(t *testing.T, repo *Repository, history []CommitNode) {
+	for i, node := range history {
+		obj, err := repo.Object(node.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit := obj.(Commit)
+		if node.Tree != commit.Tree {
+			t.Errorf("expected tree %s and received %s", commit.Tree, node.Tree)
+		}
+		if !reflect.DeepEqual(node.Parents, commit.Parents) {
+			t.Errorf("expected parents %v and received %v", commit.Parents, node.Parents)
+		}
+		if !node.CommitTime.Equal(commit.Committer.When) {
+			t.Errorf("expected commit time %s and received %s", commit.Committer.When, node.CommitTime)
+		}
+		if node.Generation < uint64(node.CommitTime.Unix()) {
+			t.Errorf("generation %d of %s is before its commit time", node.Generation, node.Name)
+		}
+		if i+1 < len(history) {
+			parent := history[i+1]
+			if node.Level != parent.Level+1 || node.Generation <= parent.Generation {
+				t.Errorf("expected %s to have a higher level and generation than its parent", node.Name)
+			}
+		} else if node.Level != 1 {
+			t.Errorf("expected root commit to have level 1 and received %d", node.Level)
+		}
+	}
+}
+
+func Test_CommitGraphChain(t *testing.T) {
+	repo := &Repository{Basedir: *RepoDir}
+	if err := repo.load(); err != nil {
+		t.Fatal(err)
+	}
+	if repo.graph == nil {
+		t.Fatal("expected a commit-graph")
+	}
+	if len(repo.graph.layers) != 2 || repo.graph.count != 18 || !repo.graph.generationData {
+		t.Errorf("expected 2 layers with 18 commits and generation data")
+	}
+
+	history, err := repo.History(mustSHA("37213e7bb3c334a0f7708c7afcab5babb3f95434"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 18 {
+		t.Fatalf("expected 18 commits and received %d", len(history))
+	}
+	checkCommitNodes(t, repo, history)
+
+	// The first layer only contains the last three commits
+	if pos, ok := repo.graph.find(mustSHA("a7f92c920ce85f07a33f948aa4fa2548b270024f")); !ok || pos >= 3 {
+		t.Errorf("expected a7f92c9 in the first layer and received position %d", pos)
+	}
+}
+
+func Test_CommitGraphSHA256(t *testing.T) {
+	repo := &Repository{Basedir: *SHA256RepoDir}
+	history, err := repo.History(mustSHA("5e47833487fbadbc14672de6c7e4b79e0f79a3bfa51c8d4307b9efaba5fdee54"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.graph == nil || len(repo.graph.layers) != 1 {
+		t.Fatal("expected a single commit-graph file")
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected 3 commits and received %d", len(history))
+	}
+	checkCommitNodes(t, repo, history)
+}
+
+func Test_CommitNodeWithoutGraph(t *testing.T) {
+	repo := &Repository{Basedir: *RefDeltaRepoDir}
+	name := mustSHA("11c786c4c55880c79b1c062f15fdac57beb0b515")
+	node, err := repo.CommitNode(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.graph != nil {
+		t.Fatal("expected no commit-graph")
+	}
+	expected := []SHA{mustSHA("e5c5d31a745624503152d1ea7ed1c21518bdb44a")}
+	if !reflect.DeepEqual(node.Parents, expected) || node.Level != 0 || node.Generation != 0 {
+		t.Errorf("expected parents %v and no generation and received %+v", expected, node)
+	}
+
+	if _, err := repo.CommitNode(node.Tree); err == nil {
+		t.Errorf("expected an error for a tree")
+	}
+}
+
+func Test_CommitGraphDamaged(t *testing.T) {
+	// A commit-graph that cannot be read is ignored
+	name := mustSHA("37213e7bb3c334a0f7708c7afcab5babb3f95434")
+	damage := map[string]func(graphs string) error{
+		"missing file": func(graphs string) error {
+			return os.Remove(filepath.Join(graphs, "graph-472edd64d00b05a2d3a5aab97cf695065580996f.graph"))
+		},
+		"newer version": func(graphs string) error {
+			filename := filepath.Join(graphs, "graph-fea1bf76bd37d7dd2f23a22cb29605bbfda07b82.graph")
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			data[4] = 2
+			return ioutil.WriteFile(filename, data, 0644)
+		},
+	}
+	for description, f := range damage {
+		dir, repo := copyRepo(t, filepath.Join("test_data", "dot_git"))
+		defer os.RemoveAll(dir)
+		if err := f(filepath.Join(repo.Basedir.Name(), "objects", "info", "commit-graphs")); err != nil {
+			t.Fatal(err)
+		}
+
+		history, err := repo.History(name)
+		if err != nil {
+			t.Errorf("%s: %s", description, err)
+			continue
+		}
+		if repo.graph != nil {
+			t.Errorf("%s: expected the commit-graph to be ignored", description)
+		}
+		if len(history) != 18 {
+			t.Errorf("%s: expected 18 commits and received %d", description, len(history))
+		}
+		if _, err := repo.Object(name); err != nil {
+			t.Errorf("%s: %s", description, err)
+		}
+	}
+}
+
+// buildFanout returns a fanout table for the given sorted names
+func buildFanout(names []SHA) string {
+	var buf bytes.Buffer
+	for b := 0; b < 256; b++ {
+		var n uint32
+		for _, name := range names {
+			if int(name.Bytes()[0]) <= b {
+				n++
+			}
+		}
+		binary.Write(&buf, binary.BigEndian, n)
+	}
+	return buf.String()
+}
+
+func Test_parseCommitGraph(t *testing.T) {
+	// An octopus merge of three commits, one of which has a commit time
+	// that needs 34 bits and a generation offset that needs 64 bits
+	names := []SHA{
+		mustSHA("0100000000000000000000000000000000000000"),
+		mustSHA("0200000000000000000000000000000000000000"),
+		mustSHA("0300000000000000000000000000000000000000"),
+		mustSHA("ff00000000000000000000000000000000000000"),
+	}
+	tree := mustSHA("4b825dc642cb6eb9a060e1bd32d5e3ffe3a3d7e3")
+	type entry struct {
+		parent1, parent2 uint32
+		level            uint32
+		commitTime       uint64
+	}
+	entries := []entry{
+		{graphParentNone, graphParentNone, 1, 100},
+		{0, graphParentNone, 2, 200},
+		{graphParentNone, graphParentNone, 1, 1 << 33},
+		{0, graphExtraEdgesNeeded, 3, 300},
+	}
+	var oidl, cdat, edge, gda2, gdo2 bytes.Buffer
+	for i, e := range entries {
+		oidl.Write(names[i].Bytes())
+		cdat.Write(tree.Bytes())
+		binary.Write(&cdat, binary.BigEndian, []uint32{e.parent1, e.parent2})
+		binary.Write(&cdat, binary.BigEndian, uint64(e.level)<<34|e.commitTime)
+	}
+	binary.Write(&edge, binary.BigEndian, []uint32{1, 2 | graphLastEdge})
+	binary.Write(&gda2, binary.BigEndian, []uint32{0, 1, graphGenerationOffsetOverflow, 1 << 30})
+	binary.Write(&gdo2, binary.BigEndian, uint64(1<<40))
+	chunks := [][2]string{
+		{graphFanout, buildFanout(names)},
+		{graphNames, oidl.String()},
+		{graphData, cdat.String()},
+		{graphGenerationData, gda2.String()},
+		{graphGenerationOverflow, gdo2.String()},
+		{graphExtraEdges, edge.String()},
+	}
+	build := func(chunks [][2]string) []byte {
+		return buildChunkFile([]byte{'C', 'G', 'P', 'H', 1, 1, byte(len(chunks)), 0}, chunks)
+	}
+
+	layer, bases, err := parseCommitGraph(build(chunks), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bases) != 0 {
+		t.Errorf("expected no base graphs and received %d", len(bases))
+	}
+	graph := newCommitGraph(SHA1, []*commitGraphLayer{layer})
+
+	pos, ok := graph.find(names[3])
+	if !ok || pos != 3 {
+		t.Fatalf("expected to find %s at position 3", names[3])
+	}
+	node, err := graph.node(pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(node.Parents, names[:3]) {
+		t.Errorf("expected parents %v and received %v", names[:3], node.Parents)
+	}
+	if node.Level != 3 || node.Generation != 300+1<<30 || node.Tree != tree {
+		t.Errorf("unexpected commit: %+v", node)
+	}
+
+	node, err = graph.node(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.CommitTime.Unix() != 1<<33 || node.Generation != 1<<33+1<<40 || len(node.Parents) != 0 {
+		t.Errorf("unexpected commit: %+v", node)
+	}
+
+	if _, ok := graph.find(mustSHA("0400000000000000000000000000000000000000")); ok {
+		t.Errorf("found a commit that is not in the commit-graph")
+	}
+
+	// Without GDA2, the level is the generation
+	layer, _, err = parseCommitGraph(build(append(chunks[:3:3], chunks[5])), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err = newCommitGraph(SHA1, []*commitGraphLayer{layer}).node(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Generation != 3 {
+		t.Errorf("expected generation 3 and received %d", node.Generation)
+	}
+
+	// Without EDGE, the octopus merge cannot be read
+	layer, _, err = parseCommitGraph(build(chunks[:5]), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newCommitGraph(SHA1, []*commitGraphLayer{layer}).node(3); err == nil {
+		t.Errorf("expected an error for an octopus merge without extra edges")
+	}
+
+	// A commit that is its own parent is rejected
+	loop := append([]byte(nil), cdat.Bytes()...)
+	binary.BigEndian.PutUint32(loop[36+20:], 1)
+	layer, _, err = parseCommitGraph(build(append([][2]string{chunks[0], chunks[1], {graphData, string(loop)}}, chunks[3:]...)), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newCommitGraph(SHA1, []*commitGraphLayer{layer}).node(1); err == nil {
+		t.Errorf("expected an error for a commit that is its own parent")
+	}
+
+	invalid := map[string][]byte{
+		"missing chunk":     build(chunks[1:]),
+		"short commit data": build(append([][2]string{chunks[0], chunks[1], {graphData, cdat.String()[1:]}}, chunks[3:]...)),
+		"missing base":      buildChunkFile([]byte{'C', 'G', 'P', 'H', 1, 1, byte(len(chunks)), 1}, chunks),
+		"wrong version":     buildChunkFile([]byte{'C', 'G', 'P', 'H', 2, 1, byte(len(chunks)), 0}, chunks),
+		"truncated":         build(chunks)[:30],
+	}
+	for name, data := range invalid {
+		if _, _, err := parseCommitGraph(data, SHA1); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, _, err := parseCommitGraph(build(chunks), SHA256); err == nil {
+		t.Errorf("expected an error for the wrong hash version")
+	}
+}
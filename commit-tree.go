@@ -0,0 +1,241 @@
+package gitgo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A TreeBuilder assembles a tree (and any nested subtrees)
+// from paths, without requiring a working tree.
+// Paths are separated by forward slashes, eg "docs/README.md".
+type TreeBuilder struct {
+	repo *Repository
+	root *builderNode
+}
+
+// builderNode is a single directory within a TreeBuilder
+type builderNode struct {
+	entries map[string]*builderEntry
+}
+
+type builderEntry struct {
+	mode FileMode
+	hash SHA
+
+	// subtree is the contents of a tree entry, once it has been
+	// read from the repository or created by the builder
+	subtree *builderNode
+}
+
+// NewTreeBuilder returns a TreeBuilder that writes to the given repository.
+// If base is non-nil, the builder starts with the entries in base;
+// otherwise, it starts with an empty tree.
+func NewTreeBuilder(repo *Repository, base *Tree) *TreeBuilder {
+	root := &builderNode{entries: map[string]*builderEntry{}}
+	if base != nil {
+		root.addEntries(base.Entries)
+	}
+	return &TreeBuilder{repo: repo, root: root}
+}
+
+func (n *builderNode) addEntries(entries []TreeEntry) {
+	for _, entry := range entries {
+		n.entries[entry.Name] = &builderEntry{mode: entry.Mode, hash: entry.Hash}
+	}
+}
+
+// Insert adds an entry at the given path, replacing any entry already there.
+// Any missing parent directories are created.
+func (b *TreeBuilder) Insert(path string, mode FileMode, hash SHA) error {
+	dir, name, err := b.parent(path, true)
+	if err != nil {
+		return err
+	}
+	dir.entries[name] = &builderEntry{mode: mode, hash: hash}
+	return nil
+}
+
+// InsertBlob writes content to the repository as a blob,
+// and adds it at the given path.
+func (b *TreeBuilder) InsertBlob(path string, mode FileMode, content io.Reader) (SHA, error) {
+	if !mode.IsBlob() {
+		return SHA{}, fmt.Errorf("mode %s is not valid for a blob", mode)
+	}
+	hash, err := b.repo.WriteObject("blob", content)
+	if err != nil {
+		return SHA{}, err
+	}
+	return hash, b.Insert(path, mode, hash)
+}
+
+// Remove deletes the entry at the given path. If this leaves
+// a directory empty, the directory is removed as well, since git
+// does not store empty directories.
+func (b *TreeBuilder) Remove(path string) error {
+	components, err := splitPath(path)
+	if err != nil {
+		return err
+	}
+	_, err = b.remove(b.root, components, path)
+	return err
+}
+
+func (b *TreeBuilder) remove(node *builderNode, components []string, path string) (empty bool, err error) {
+	entry, ok := node.entries[components[0]]
+	if !ok {
+		return false, fmt.Errorf("path not found: %s", path)
+	}
+	if len(components) == 1 {
+		delete(node.entries, components[0])
+		return len(node.entries) == 0, nil
+	}
+
+	subtree, err := b.subtree(entry, components[0])
+	if err != nil {
+		return false, err
+	}
+	empty, err = b.remove(subtree, components[1:], path)
+	if err != nil {
+		return false, err
+	}
+	if empty {
+		delete(node.entries, components[0])
+	}
+	return len(node.entries) == 0, nil
+}
+
+// parent returns the directory containing the path, along with the final path component.
+func (b *TreeBuilder) parent(path string, create bool) (*builderNode, string, error) {
+	components, err := splitPath(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	node := b.root
+	for _, component := range components[:len(components)-1] {
+		entry, ok := node.entries[component]
+		if !ok {
+			if !create {
+				return nil, "", fmt.Errorf("path not found: %s", path)
+			}
+			entry = &builderEntry{mode: ModeTree, subtree: &builderNode{entries: map[string]*builderEntry{}}}
+			node.entries[component] = entry
+		}
+		node, err = b.subtree(entry, component)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return node, components[len(components)-1], nil
+}
+
+// subtree returns the contents of a tree entry, reading it from the repository if necessary
+func (b *TreeBuilder) subtree(entry *builderEntry, name string) (*builderNode, error) {
+	if !entry.mode.IsTree() {
+		return nil, fmt.Errorf("%s is not a directory", name)
+	}
+	if entry.subtree != nil {
+		return entry.subtree, nil
+	}
+
+	obj, err := b.repo.Object(entry.hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, ok := obj.(Tree)
+	if !ok {
+		return nil, fmt.Errorf("%s refers to a %s, not a tree", name, obj.Type())
+	}
+	entry.subtree = &builderNode{entries: map[string]*builderEntry{}}
+	entry.subtree.addEntries(tree.Entries)
+	return entry.subtree, nil
+}
+
+func splitPath(path string) ([]string, error) {
+	components := strings.Split(strings.Trim(path, "/"), "/")
+	for _, component := range components {
+		// git's fsck rejects .git in any case, since some filesystems ignore case
+		switch {
+		case component == "", component == ".", component == "..",
+			strings.EqualFold(component, ".git"), strings.IndexByte(component, 0) >= 0:
+			return nil, fmt.Errorf("invalid path: %q", path)
+		}
+	}
+	return components, nil
+}
+
+// Write writes the tree, and any subtrees that have changed,
+// to the repository, and returns the name of the root tree.
+func (b *TreeBuilder) Write() (SHA, error) {
+	return b.write(b.root)
+}
+
+func (b *TreeBuilder) write(node *builderNode) (SHA, error) {
+	var tree Tree
+	for name, entry := range node.entries {
+		if entry.subtree != nil {
+			if len(entry.subtree.entries) == 0 {
+				continue
+			}
+			hash, err := b.write(entry.subtree)
+			if err != nil {
+				return SHA{}, err
+			}
+			entry.hash = hash
+		}
+		tree.Entries = append(tree.Entries, TreeEntry{Name: name, Mode: entry.mode, Hash: entry.hash})
+	}
+	sortTreeEntries(tree.Entries)
+
+	contents, err := tree.encode()
+	if err != nil {
+		return SHA{}, err
+	}
+	return b.repo.WriteObject("tree", bytes.NewReader(contents))
+}
+
+// sortTreeEntries sorts entries into the order that git requires:
+// by name, except that the name of a tree is compared as if it ended in "/"
+func sortTreeEntries(entries []TreeEntry) {
+	key := func(entry TreeEntry) string {
+		if entry.Mode.IsTree() {
+			return entry.Name + "/"
+		}
+		return entry.Name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return key(entries[i]) < key(entries[j])
+	})
+}
+
+// CreateCommit writes a commit to the repository and returns its name.
+// The commit's Tree and Parents must already exist in the repository.
+// If the Committer is not set, the Author is used instead.
+// Both must have a name, email, and time.
+// Like `git commit-tree`, it does not update any refs.
+func (r *Repository) CreateCommit(commit Commit) (SHA, error) {
+	err := r.load()
+	if err != nil {
+		return SHA{}, err
+	}
+	if commit.Tree.IsZero() {
+		return SHA{}, fmt.Errorf("commit has no tree")
+	}
+	for _, name := range append([]SHA{commit.Tree}, commit.Parents...) {
+		if !r.hasObject(name) {
+			return SHA{}, fmt.Errorf("object not found: %s", name)
+		}
+	}
+	if commit.Committer == (Signature{}) {
+		commit.Committer = commit.Author
+	}
+	for i, sig := range []Signature{commit.Author, commit.Committer} {
+		if sig.Name == "" || sig.Email == "" || sig.When.IsZero() {
+			return SHA{}, fmt.Errorf("commit %s must have a name, email, and time", []keyType{authorKey, committerKey}[i])
+		}
+	}
+	return r.WriteObject("commit", bytes.NewReader(commit.encode()))
+}
@@ -0,0 +1,165 @@
+package gitgo
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_TreeBuilder(t *testing.T) {
+	dir, repo := tempRepo(t)
+	defer os.RemoveAll(dir)
+
+	b := NewTreeBuilder(repo, nil)
+	inserts := []struct {
+		path string
+		hash SHA
+	}{
+		{"examples/.gitkeep", mustSHA("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391")},
+		{"cat-file_test.go", mustSHA("2c225b962d6666011c69ca5c2c67204959f8ba32")},
+		{".gitignore", mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")},
+		{"cat-file.go", mustSHA("f45d37d9add8f21eb84678f6d2c66377c4dd0c5e")},
+	}
+	for _, insert := range inserts {
+		if err := b.Insert(insert.path, ModeRegular, insert.hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name, err := b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != mustSHA("1efecd717188441397c07f267cf468fdf04d4796") {
+		t.Errorf("received incorrect tree %s", name)
+	}
+
+	if err := b.Remove("examples/.gitkeep"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove(".gitignore"); err != nil {
+		t.Fatal(err)
+	}
+	name, err = b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the empty examples directory is removed
+	if name != mustSHA("681f1ce13ca55b9e94d876a3f8ab64dcb76a5f87") {
+		t.Errorf("received incorrect tree %s after removing entries", name)
+	}
+
+	if err := b.Remove("nonexistent/file"); err == nil {
+		t.Errorf("expected an error removing a nonexistent path")
+	}
+	if err := b.Insert("cat-file.go/child", ModeRegular, mustSHA("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391")); err == nil {
+		t.Errorf("expected an error inserting beneath a file")
+	}
+	for _, path := range []string{".git/config", "a/.GIT/config", ".Git", "a/../b", "nul\x00byte"} {
+		if err := b.Insert(path, ModeRegular, mustSHA("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391")); err == nil {
+			t.Errorf("expected an error inserting %q", path)
+		}
+	}
+}
+
+func Test_TreeBuilderBase(t *testing.T) {
+	dir, repo := tempRepo(t)
+	defer os.RemoveAll(dir)
+
+	b := NewTreeBuilder(repo, nil)
+	b.Insert("examples/.gitkeep", ModeRegular, mustSHA("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"))
+	b.Insert("cat-file.go", ModeRegular, mustSHA("f45d37d9add8f21eb84678f6d2c66377c4dd0c5e"))
+	b.Insert("cat-file_test.go", ModeRegular, mustSHA("2c225b962d6666011c69ca5c2c67204959f8ba32"))
+	b.Insert(".gitignore", ModeRegular, mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"))
+	name, err := b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := repo.Object(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := obj.(Tree)
+
+	// modify a nested path, which requires reading the existing subtree
+	b = NewTreeBuilder(repo, &base)
+	if _, err := b.InsertBlob("examples/sub/file.txt", ModeRegular, strings.NewReader("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove(".gitignore"); err != nil {
+		t.Fatal(err)
+	}
+	name, err = b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != mustSHA("be7982648b660f6ed3332b250e2bd0fc4cfb7a61") {
+		t.Errorf("received incorrect tree %s", name)
+	}
+}
+
+func Test_sortTreeEntries(t *testing.T) {
+	dir, repo := tempRepo(t)
+	defer os.RemoveAll(dir)
+
+	// trees sort as though their names end in "/"
+	b := NewTreeBuilder(repo, nil)
+	b.Insert("a0", ModeExecutable, mustSHA("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"))
+	b.Insert("a", ModeTree, mustSHA("d564d0bc3dd917926892c55e3706cc116d5b165e"))
+	b.Insert("a.b", ModeRegular, mustSHA("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"))
+	b.Insert("a-", ModeRegular, mustSHA("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"))
+	name, err := b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != mustSHA("aece7ae3b2436bc41bc992c5266eb5ce1b8cd167") {
+		t.Errorf("received incorrect tree %s", name)
+	}
+}
+
+func Test_CreateCommit(t *testing.T) {
+	dir, repo := tempRepo(t)
+	defer os.RemoveAll(dir)
+
+	b := NewTreeBuilder(repo, nil)
+	if _, err := b.InsertBlob(".gitignore", ModeRegular, strings.NewReader("*.swp\n*.swo\n*.swn\n")); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	author := Signature{
+		Name:   "aditya",
+		Email:  "dev@chimeracoder.net",
+		When:   time.Unix(1428075900, 0),
+		Offset: "-0400",
+	}
+	name, err := repo.CreateCommit(Commit{
+		Tree:    tree,
+		Author:  author,
+		Message: []byte("First commit. Create .gitignore\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != mustSHA("97eed02ebe122df8fdd853c1215d8775f3d9f1a1") {
+		t.Errorf("received incorrect commit %s", name)
+	}
+
+	_, err = repo.CreateCommit(Commit{
+		Tree:    tree,
+		Parents: []SHA{mustSHA("1d833eb5b6c5369c0cb7a4a3e20ded237490145f")},
+		Author:  author,
+	})
+	if err == nil {
+		t.Errorf("expected an error for a missing parent")
+	}
+
+	for _, sig := range []Signature{{}, {Name: "aditya", When: author.When}, {Name: "aditya", Email: author.Email}} {
+		if _, err := repo.CreateCommit(Commit{Tree: tree, Author: sig}); err == nil {
+			t.Errorf("expected an error for author %+v", sig)
+		}
+	}
+}
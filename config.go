@@ -0,0 +1,148 @@
+package gitgo
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// config holds the options set in a repository's config file.
+// Keys are written as "section.key" (or "section.subsection.key"),
+// with the section and key in lowercase, since git treats them
+// case-insensitively.
+type config map[string]string
+
+// readConfig reads the config file in the given git directory.
+// A repository without a config file has no options set.
+func readConfig(gitdir string) (config, error) {
+	cfg := config{}
+	f, err := os.Open(filepath.Join(gitdir, "config"))
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var section string
+	scnr := bufio.NewScanner(f)
+	for lineNumber := 1; scnr.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scnr.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid section header in config, line %d: %s", lineNumber, line)
+			}
+			parts := strings.SplitN(line[1:end], " ", 2)
+			section = strings.ToLower(parts[0])
+			if len(parts) == 2 {
+				// subsection names are case-sensitive
+				section += "." + strings.Trim(strings.TrimSpace(parts[1]), `"`)
+			}
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" {
+				continue
+			}
+		}
+
+		if section == "" {
+			return nil, fmt.Errorf("config option outside of a section, line %d: %s", lineNumber, line)
+		}
+		key, value := line, "true"
+		if eq := strings.IndexByte(line, '='); eq >= 0 {
+			key, value = strings.TrimSpace(line[:eq]), parseConfigValue(line[eq+1:])
+		}
+		cfg[section+"."+strings.ToLower(key)] = value
+	}
+	return cfg, scnr.Err()
+}
+
+// parseConfigValue removes comments and quotes from a config value
+func parseConfigValue(value string) string {
+	var result []byte
+	var quoted bool
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && i+1 < len(value):
+			i++
+			switch value[i] {
+			case 'n':
+				result = append(result, '\n')
+			case 't':
+				result = append(result, '\t')
+			default:
+				result = append(result, value[i])
+			}
+		case (c == '#' || c == ';') && !quoted:
+			return strings.TrimSpace(string(result))
+		default:
+			result = append(result, c)
+		}
+	}
+	return strings.TrimSpace(string(result))
+}
+
+// objectFormat returns the object format that the config specifies
+func (c config) objectFormat() (ObjectFormat, error) {
+	format, ok := c["extensions.objectformat"]
+	if !ok {
+		return SHA1, nil
+	}
+	switch ObjectFormat(strings.ToLower(format)) {
+	case SHA1:
+		return SHA1, nil
+	case SHA256:
+		return SHA256, nil
+	default:
+		return "", fmt.Errorf("unsupported object format: %s", format)
+	}
+}
+
+// deltaBaseCacheLimit returns core.deltaBaseCacheLimit, in bytes,
+// or DefaultDeltaBaseCacheLimit if it is not set
+func (c config) deltaBaseCacheLimit() (int64, error) {
+	value, ok := c["core.deltabasecachelimit"]
+	if !ok {
+		return DefaultDeltaBaseCacheLimit, nil
+	}
+	return parseConfigInt(value)
+}
+
+// parseConfigInt parses an integer config value, which may have
+// a suffix of k, m, or g to multiply it by 1024, 1024^2, or 1024^3
+func parseConfigInt(value string) (int64, error) {
+	var unit int64 = 1
+	if value != "" {
+		switch strings.ToLower(value[len(value)-1:]) {
+		case "k":
+			unit = 1 << 10
+		case "m":
+			unit = 1 << 20
+		case "g":
+			unit = 1 << 30
+		}
+	}
+	if unit != 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer in config: %s", value)
+	}
+	if n > math.MaxInt64/unit || n < math.MinInt64/unit {
+		return 0, fmt.Errorf("integer in config is out of range: %s", value)
+	}
+	return n * unit, nil
+}
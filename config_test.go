@@ -0,0 +1,83 @@
+package gitgo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_readConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const contents = `# a comment
+[core]
+	repositoryformatversion = 1
+	bare
+[Extensions]
+	objectFormat = SHA256 ; trailing comment
+[remote "origin"]
+	url = "https://example.com/a;b.git"
+`
+	err = ioutil.WriteFile(filepath.Join(dir, "config"), []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := config{
+		"core.repositoryformatversion": "1",
+		"core.bare":                    "true",
+		"extensions.objectformat":      "SHA256",
+		"remote.origin.url":            "https://example.com/a;b.git",
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %v and received %v", expected, cfg)
+	}
+
+	format, err := cfg.objectFormat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != SHA256 {
+		t.Errorf("expected %s and received %s", SHA256, format)
+	}
+
+	if _, err := (config{"extensions.objectformat": "md5"}).objectFormat(); err == nil {
+		t.Errorf("expected an error for an unsupported object format")
+	}
+}
+
+func Test_parseConfigInt(t *testing.T) {
+	cases := map[string]int64{
+		"0":    0,
+		"1234": 1234,
+		"-1":   -1,
+		"96m":  96 << 20,
+		"8K":   8 << 10,
+		"2g":   2 << 30,
+	}
+	for input, expected := range cases {
+		n, err := parseConfigInt(input)
+		if err != nil {
+			t.Errorf("%s: %s", input, err)
+			continue
+		}
+		if n != expected {
+			t.Errorf("expected %d and received %d", expected, n)
+		}
+	}
+
+	for _, invalid := range []string{"", "m", "12x", "99999999999g"} {
+		if _, err := parseConfigInt(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
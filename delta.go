@@ -1,94 +1,97 @@
 package gitgo
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
+	"math"
 )
 
 // patchDelta will apply a delta to a base.
+// The delta may come from an untrusted packfile, so every instruction is checked:
+// copies must lie within the base, the result may not grow beyond the target size
+// declared in the delta, and it must reach exactly that size.
 func patchDelta(start io.ReadSeeker, delta io.Reader) (io.Reader, error) {
-	base := errReadSeeker{start, nil}
-	deltar := newErrReader(delta)
+	deltar, ok := delta.(io.ByteReader)
+	if !ok {
+		br := bufio.NewReader(delta)
+		delta, deltar = br, br
+	}
 
 	// First, read the source and target lengths (varints)
-	// we can ignore err as long as we check deltar.err at the end
-	// we have no need for the targetLength right now, so we discard it
-	sourceLength, _ := parseVarInt(deltar)
-	_, _ = parseVarInt(deltar)
-	if deltar.err != nil {
-		return nil, deltar.err
+	sourceLength, err := parseVarInt(delta)
+	if err != nil {
+		return nil, fmt.Errorf("reading delta source length: %s", err)
+	}
+	targetLength, err := parseVarInt(delta)
+	if err != nil {
+		return nil, fmt.Errorf("reading delta target length: %s", err)
 	}
 
+	n, err := start.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if n != int64(sourceLength) {
+		return nil, fmt.Errorf("delta expects a base of %d bytes, but the base is %d bytes", sourceLength, n)
+	}
+
+	// The target length is not trusted, so the result grows as instructions
+	// are applied, rather than being allocated up front
 	result := bytes.NewBuffer(nil)
-	//result = io.MultiWriter(result, os.Stderr)
 
 	// Now, the rest of the bytes are either copy or insert instructions
 	// If the MSB is set, it is a copy
-
 	for {
-		bs := make([]byte, 1)
-		n := deltar.read(bs)
-		if n == 0 {
+		b, err := deltar.ReadByte()
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return nil, err
+		}
 
-		// b represents our command itself (opcode)
-		b := bs[0]
 		switch b & 128 {
 		case 128:
-			// b is a copy instruction
-
-			// the last four bits represent the offset from the base (source)
-			var baseOffset int
-			if (b & 1) > 0 {
-				baseOffset = baseOffset | int(uint(deltar.readByte()))
-			}
-
-			if (b & 2) > 0 {
-				baseOffset = baseOffset | int((uint(deltar.readByte()) << 8))
-			}
-
-			if (b & 4) > 0 {
-				baseOffset = baseOffset | int((uint(deltar.readByte()) << 16))
+			// b is a copy instruction. Its lower four bits say which bytes
+			// of the offset follow, and the next three say which bytes of the size follow
+			var baseOffset, numBytes uint64
+			for i := uint(0); i < 7; i++ {
+				if b&(1<<i) == 0 {
+					continue
+				}
+				c, err := deltar.ReadByte()
+				if err != nil {
+					return nil, fmt.Errorf("delta is truncated in a copy instruction: %s", unexpectedEOF(err))
+				}
+				if i < 4 {
+					baseOffset |= uint64(c) << (8 * i)
+				} else {
+					numBytes |= uint64(c) << (8 * (i - 4))
+				}
 			}
 
-			if (b & 8) > 0 {
-				baseOffset = baseOffset | int((uint(deltar.readByte()) << 24))
-			}
-
-			// read the number of bytes to copy from source→target
-			// if the fifth bit from the right is set, read the next byte
-			// The number of copy bytes must fit into
-
-			var numBytes uint
-
-			if (b & 16) > 0 {
-				numBytes = numBytes | uint(uint(deltar.readByte()))
-			}
-			if (b & 32) > 0 {
-				numBytes = numBytes | uint((uint(deltar.readByte()) << 8))
+			// A size of zero means 0x10000
+			if numBytes == 0 {
+				numBytes = 0x10000
 			}
 
-			if (b & 64) > 0 {
-				numBytes = numBytes | uint((uint(deltar.readByte()) << 16))
+			if baseOffset+numBytes > uint64(sourceLength) {
+				return nil, fmt.Errorf("delta copies %d bytes from offset %d, beyond the end of the %d-byte base", numBytes, baseOffset, sourceLength)
 			}
-
-			// Default to 0x10000 due to overflow
-			if numBytes == 0 {
-				numBytes = 65536
+			if uint64(result.Len())+numBytes > uint64(targetLength) {
+				return nil, fmt.Errorf("delta produces more than its declared target size of %d bytes", targetLength)
 			}
 
 			// read numBytes from source, starting at baseOffset
 			// and write that to the target
-			base.Seek(int64(baseOffset), os.SEEK_SET)
-			buf := make([]byte, numBytes)
-			base.read(buf)
-
-			_, err := result.Write(buf)
-			if err != nil {
-				return result, err
+			if _, err := start.Seek(int64(baseOffset), io.SeekStart); err != nil {
+				return nil, err
+			}
+			if _, err := io.CopyN(result, start, int64(numBytes)); err != nil {
+				return nil, fmt.Errorf("copying %d bytes from offset %d of the base: %s", numBytes, baseOffset, unexpectedEOF(err))
 			}
 
 		case 0:
@@ -102,40 +105,44 @@ func patchDelta(start io.ReadSeeker, delta io.Reader) (io.Reader, error) {
 
 			// b itself tells us the number of bytes to write to the target
 			// the MSB is not set, so the maximum number to insert is 127 bytes
-
 			numBytes := int(b)
-			buf := make([]byte, numBytes)
-			deltar.read(buf)
-			_, err := result.Write(buf)
-			if err != nil {
-				return nil, err
+			if result.Len()+numBytes > targetLength {
+				return nil, fmt.Errorf("delta produces more than its declared target size of %d bytes", targetLength)
+			}
+			if _, err := io.CopyN(result, delta, int64(numBytes)); err != nil {
+				return nil, fmt.Errorf("delta is truncated in an insert of %d bytes: %s", numBytes, unexpectedEOF(err))
 			}
-
-		default:
-			return nil, fmt.Errorf("invalid opcode %08b", b)
 		}
 	}
 
-	n, err := base.Seek(0, os.SEEK_END)
-	if err != nil {
-		return nil, err
-	}
-	if n != int64(sourceLength) {
-		return nil, fmt.Errorf("expected to read %d bytes and read %d", sourceLength, n)
+	if result.Len() != targetLength {
+		return nil, fmt.Errorf("delta produced %d bytes, but declared a target size of %d bytes", result.Len(), targetLength)
 	}
+	return result, nil
+}
 
-	if deltar.err == io.EOF {
-		return result, nil
+// unexpectedEOF converts io.EOF, which is returned when a stream ends
+// between two reads, to io.ErrUnexpectedEOF, since the stream should not have ended
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
 	}
-	return result, deltar.err
+	return err
 }
 
+// maxInt is the largest int on this platform
+const maxInt = int(^uint(0) >> 1)
+
+// maxVarIntBytes is the most bytes that parseVarInt will read.
+// Ten bytes can hold any 64-bit number.
+const maxVarIntBytes = 10
+
 func parseVarInt(r io.Reader) (int, error) {
 	// The MSB of the first byte indicates whether to read
 	// the next byte
 
 	_bytes := make([]byte, 1)
-	_, err := r.Read(_bytes)
+	_, err := io.ReadFull(r, _bytes)
 	if err != nil {
 		return 0, err
 	}
@@ -147,7 +154,7 @@ func parseVarInt(r io.Reader) (int, error) {
 	MSB := (_byte & 128) // will be either 128 or 0
 
 	// This will extract the last seven bits of the byte
-	var objectSize = int((uint(_byte) & 127))
+	var objectSize = uint64(_byte & 127)
 
 	// shift the first size by 0
 	// and the rest by (i-1) * 7
@@ -155,68 +162,219 @@ func parseVarInt(r io.Reader) (int, error) {
 
 	// If the most-significant bit is 0, this is the last byte
 	// for the object size
-	for MSB > 0 {
+	for i := 1; MSB > 0; i++ {
+		if i == maxVarIntBytes {
+			return 0, fmt.Errorf("varint is longer than %d bytes", maxVarIntBytes)
+		}
 		shift += 7
 		// Keep reading the size until the MSB is 0
-		_bytes := make([]byte, 1)
-		_, err := r.Read(_bytes)
+		_, err := io.ReadFull(r, _bytes)
 		if err != nil {
-			return 0, err
+			return 0, unexpectedEOF(err)
 		}
 		_byte := _bytes[0]
 
 		MSB = (_byte & 128)
 
-		objectSize += int((uint(_byte) & 127) << shift)
+		objectSize |= uint64(_byte&127) << shift
 	}
-	return objectSize, nil
+	if objectSize > uint64(maxInt) {
+		return 0, fmt.Errorf("size %d is too large", objectSize)
+	}
+	return int(objectSize), nil
 }
 
-type errReader struct {
-	r   io.Reader
-	n   int
-	err error
+// deltaBlockSize is the length of the blocks of the base that CreateDelta indexes.
+// Matches shorter than this are not found, and are inserted instead.
+// Each block is read as a single uint64 when it is hashed, so it must be 8 bytes.
+const deltaBlockSize = 8
+
+// maxDeltaCandidates limits the number of positions in the base that are
+// compared to each position in the target, so that repetitive data (such as runs
+// of indentation in source code) cannot make CreateDelta quadratic
+const maxDeltaCandidates = 64
+
+// maxCopySize is the largest copy instruction that CreateDelta emits.
+// The format allows copies of up to 2^24 - 1 bytes, but git
+// only emits copies of up to 64 KiB, and some readers depend on that.
+const maxCopySize = 0x10000
+
+// CreateDelta returns a delta that reconstructs target when it is applied to base.
+// The delta uses the same format as git: the sizes of the base and target
+// as varints, followed by instructions that either copy a range of the base
+// or insert new data. It is the inverse of patchDelta.
+//
+// Matches of at least 8 bytes are found anywhere in the base,
+// which suits source code, where small edits are common.
+// Matches that would take more space to copy than to insert are inserted.
+func CreateDelta(base, target []byte) []byte {
+	return newDeltaIndex(base).delta(target)
 }
 
-// Turn an io.Reader into an errReader.
-// If r is already an io.Reader, this is a no-op
-func newErrReader(r io.Reader) errReader {
-	er, ok := r.(errReader)
-	if !ok {
-		er = errReader{r, 0, nil}
+// deltaIndex records the position of each block of a delta base,
+// so that the base can be compared to several targets
+// without being indexed again. It is a hash table of blocks, in which
+// the positions with the same hash are chained together.
+// Copy instructions cannot refer to offsets beyond 32 bits, so neither can the index.
+type deltaIndex struct {
+	base  []byte
+	shift uint
+
+	// head holds the last position with each hash, and next holds the previous
+	// position with the same hash as each position. Both are offset by one,
+	// so that zero marks the end of a chain.
+	head []uint32
+	next []uint32
+}
+
+func newDeltaIndex(base []byte) *deltaIndex {
+	d := &deltaIndex{base: base, shift: 64}
+	n := len(base) - deltaBlockSize + 1
+	if n <= 0 {
+		return d
+	}
+	if n > math.MaxUint32-1 {
+		n = math.MaxUint32 - 1
 	}
-	return er
+	for size := 1; size < n; size <<= 1 {
+		d.shift--
+	}
+	d.head = make([]uint32, 1<<(64-d.shift))
+	d.next = make([]uint32, n)
+	for i := 0; i < n; i++ {
+		h := d.hash(base[i:])
+		d.next[i] = d.head[h]
+		d.head[h] = uint32(i + 1)
+	}
+	return d
 }
 
-// Read, but only if no errors have been encountered
-// in a previous read (including io.EOF)
-func (er *errReader) read(buf []byte) int {
-	var n int
-	if er.err != nil {
+// headAt returns the last position in the base, offset by one, whose
+// block has the same hash as the block at the start of b, or zero if there is none
+func (d *deltaIndex) headAt(b []byte) uint32 {
+	if d.head == nil {
 		return 0
 	}
-	n, er.err = io.ReadFull(er.r, buf)
-	er.n += n
-	return n
+	return d.head[d.hash(b)]
 }
 
-// Like read(), but expect a single byte
-func (er *errReader) readByte() byte {
-	b := make([]byte, 1)
-	n := er.read(b)
-	if n != 1 && er.err != nil {
-		if er.err == io.EOF {
-			er.err = io.EOF
-			return b[0]
+// hash returns the position in d.head of the block at the start of b
+func (d *deltaIndex) hash(b []byte) uint64 {
+	return (binary.LittleEndian.Uint64(b) * 0x9e3779b97f4a7c15) >> d.shift
+}
+
+// delta returns a delta that reconstructs target from the indexed base.
+// Each position in the target is looked up among the blocks of the base;
+// every match is extended as far as possible and becomes a copy instruction,
+// and everything else is inserted.
+func (d *deltaIndex) delta(target []byte) []byte {
+	base := d.base
+	var delta []byte
+	delta = appendVarInt(delta, len(base))
+	delta = appendVarInt(delta, len(target))
+
+	// insertStart is the start of the bytes in the target
+	// that have not been copied or inserted yet
+	insertStart := 0
+	for i := 0; i+deltaBlockSize <= len(target); {
+		var bestOffset, bestLength, bestBack int
+		var candidates int
+		for next := d.headAt(target[i:]); next > 0 && candidates < maxDeltaCandidates; next = d.next[next-1] {
+			offset := int(next - 1)
+			candidates++
+			length := 0
+			for offset+length < len(base) && i+length < len(target) && base[offset+length] == target[i+length] {
+				length++
+			}
+			if length < deltaBlockSize {
+				// The blocks have the same hash, but are different
+				continue
+			}
+			// The match may also extend backwards into bytes
+			// that would otherwise be inserted
+			back := 0
+			for offset-back > 0 && i-back > insertStart && base[offset-back-1] == target[i-back-1] {
+				back++
+			}
+			if length+back > bestLength+bestBack {
+				bestOffset, bestLength, bestBack = offset, length, back
+			}
+		}
+		if bestLength == 0 || bestLength+bestBack <= copyCost(bestOffset-bestBack, bestLength+bestBack) {
+			i++
+			continue
 		}
-		er.err = fmt.Errorf("expected to read single byte and read none")
+
+		delta = appendInserts(delta, target[insertStart:i-bestBack])
+		delta = appendCopies(delta, bestOffset-bestBack, bestLength+bestBack)
+		i += bestLength
+		insertStart = i
+	}
+	return appendInserts(delta, target[insertStart:])
+}
+
+// copyCost returns the number of bytes in the copy instructions that appendCopies
+// would append, so that short matches can be inserted instead
+func copyCost(offset, length int) int {
+	return len(appendCopies(nil, offset, length))
+}
+
+// appendVarInt appends n in the format read by parseVarInt:
+// seven bits at a time, least significant first, with the MSB
+// of each byte set if another byte follows
+func appendVarInt(b []byte, n int) []byte {
+	for n >= 128 {
+		b = append(b, byte(n&127)|128)
+		n >>= 7
+	}
+	return append(b, byte(n))
+}
+
+// appendInserts appends insert instructions for data,
+// each of which can insert at most 127 bytes
+func appendInserts(b []byte, data []byte) []byte {
+	for len(data) > 0 {
+		n := len(data)
+		if n > 127 {
+			n = 127
+		}
+		b = append(b, byte(n))
+		b = append(b, data[:n]...)
+		data = data[n:]
 	}
-	return b[0]
+	return b
 }
 
-// Should not actually be called
-// Defined only to ensure that errReader is itself an ioReader
-func (er errReader) Read(buf []byte) (int, error) {
-	n := er.read(buf)
-	return n, er.err
+// appendCopies appends copy instructions for length bytes of the base,
+// starting at offset. Each byte of the offset and size that is zero
+// is omitted, and its bit in the opcode is left unset.
+func appendCopies(b []byte, offset, length int) []byte {
+	for length > 0 {
+		size := length
+		if size > maxCopySize {
+			size = maxCopySize
+		}
+
+		pos := len(b)
+		op := byte(128)
+		b = append(b, 0)
+		for i := uint(0); i < 4; i++ {
+			if c := byte(offset >> (8 * i)); c != 0 {
+				op |= 1 << i
+				b = append(b, c)
+			}
+		}
+		// A size of 0x10000 is encoded by omitting the size entirely
+		for i := uint(0); i < 3; i++ {
+			if c := byte(size >> (8 * i)); c != 0 {
+				op |= 16 << i
+				b = append(b, c)
+			}
+		}
+		b[pos] = op
+
+		offset += size
+		length -= size
+	}
+	return b
 }
@@ -5,6 +5,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"reflect"
 	"testing"
@@ -70,6 +71,39 @@ func Test_PatchDelta(t *testing.T) {
 	}
 }
 
+func Test_PatchDeltaInvalid(t *testing.T) {
+	base := []byte("hello world")
+	cases := map[string][]byte{
+		"empty":                    {},
+		"missing target size":      {11},
+		"wrong base size":          {10, 5, 0x90 | 1, 0, 5},
+		"copy beyond base":         {11, 5, 0x80 | 0x10 | 1, 8, 5},
+		"copy beyond target":       {11, 5, 0x80 | 0x10, 6},
+		"default size beyond base": {11, 5, 0x80},
+		"insert beyond target":     {11, 2, 3, 'a', 'b', 'c'},
+		"truncated insert":         {11, 5, 5, 'a', 'b'},
+		"truncated copy":           {11, 5, 0x80 | 0x10 | 1, 0},
+		"shorter than target":      {11, 5, 0x80 | 0x10, 3},
+		"reserved opcode":          {11, 0, 0},
+		"varint overflow":          {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0},
+		"truncated target size":    {11, 0x80},
+	}
+	for name, delta := range cases {
+		if _, err := patchDelta(bytes.NewReader(base), bytes.NewReader(delta)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// Copy "world" and insert "!"
+	patched, err := patchDelta(bytes.NewReader(base), bytes.NewReader([]byte{11, 6, 0x80 | 0x10 | 1, 6, 5, 1, '!'}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := ioutil.ReadAll(patched); string(result) != "world!" {
+		t.Errorf("expected world! and received %q", result)
+	}
+}
+
 func Test_parseVarInt(t *testing.T) {
 	type pair struct {
 		b []byte
@@ -110,3 +144,50 @@ func readersEqual(r1, r2 io.Reader) bool {
 	}
 	return true
 }
+
+func Test_CreateDelta(t *testing.T) {
+	zlib, err := ioutil.ReadFile("test_data/zlib.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := ioutil.ReadFile("test_data/zlib-changed.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := bytes.Repeat(zlib, 2*maxCopySize/len(zlib)+1)
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	cases := []struct {
+		base, target []byte
+	}{
+		{zlib, changed},
+		{changed, zlib},
+		{zlib, zlib},
+		{nil, zlib},
+		{zlib, nil},
+		{large, append(large[1:], 'x')},
+		{zlib, random},
+		{random, append(random[2048:], random[:2048]...)},
+		{bytes.Repeat([]byte{'\t'}, 1000), bytes.Repeat([]byte{'\t'}, 3000)},
+	}
+	for i, c := range cases {
+		delta := CreateDelta(c.base, c.target)
+		patched, err := patchDelta(bytes.NewReader(c.base), bytes.NewReader(delta))
+		if err != nil {
+			t.Errorf("case %d: %s", i, err)
+			continue
+		}
+		result, err := ioutil.ReadAll(patched)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(result, c.target) {
+			t.Errorf("case %d: patched delta does not match target", i)
+		}
+	}
+
+	if delta := CreateDelta(zlib, changed); len(delta) > len(changed)/10 {
+		t.Errorf("expected a small delta and received %d bytes", len(delta))
+	}
+}
@@ -0,0 +1,184 @@
+package gitgo
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Encode returns the contents of the commit in git's canonical format,
+// without the "<type> <size>" header. Headers are written in the order
+// that git writes them: tree, parents, author, committer, encoding,
+// mergetags, any extra headers, and finally the signatures.
+// A commit that was parsed with its headers in another order keeps that order.
+func (c Commit) Encode() ([]byte, error) {
+	return c.encode(), nil
+}
+
+// MarshalBinary is equivalent to Encode
+func (c Commit) MarshalBinary() ([]byte, error) {
+	return c.Encode()
+}
+
+// UnmarshalBinary parses the contents of a commit object (without the header).
+// The commit's Name is computed from the contents, using SHA-1.
+func (c *Commit) UnmarshalBinary(data []byte) error {
+	name, err := HashObject("commit", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	*c, err = parseCommit(bytes.NewReader(data), strconv.Itoa(len(data)), name)
+	return err
+}
+
+func (c Commit) encode() []byte {
+	var b bytes.Buffer
+	headers := c.headers()
+
+	// A commit whose headers were parsed in a different order is written
+	// in that order, so that its name does not change. Any headers that
+	// have been added since then are written afterward.
+	written := make([]bool, len(headers))
+	for _, key := range c.headerOrder {
+		for i, header := range headers {
+			if !written[i] && header.Key == key {
+				writeHeader(&b, keyType(header.Key), header.Value)
+				written[i] = true
+				break
+			}
+		}
+	}
+	for i, header := range headers {
+		if !written[i] {
+			writeHeader(&b, keyType(header.Key), header.Value)
+		}
+	}
+	b.WriteByte('\n')
+	b.Write(c.Message)
+	return b.Bytes()
+}
+
+// headers returns the headers of the commit, in the order that git writes them
+func (c Commit) headers() []ExtraHeader {
+	headers := []ExtraHeader{{string(treeKey), []byte(c.Tree.String())}}
+	for _, parent := range c.Parents {
+		headers = append(headers, ExtraHeader{string(parentKey), []byte(parent.String())})
+	}
+	headers = append(headers,
+		ExtraHeader{string(authorKey), []byte(c.Author.String())},
+		ExtraHeader{string(committerKey), []byte(c.Committer.String())})
+	if c.Encoding != "" {
+		headers = append(headers, ExtraHeader{string(encodingKey), []byte(c.Encoding)})
+	}
+	for _, tag := range c.MergeTags {
+		headers = append(headers, ExtraHeader{string(mergetagKey), bytes.TrimSuffix(tag, []byte("\n"))})
+	}
+	headers = append(headers, c.ExtraHeaders...)
+	if c.GPGSig != nil {
+		headers = append(headers, ExtraHeader{string(gpgsigKey), bytes.TrimSuffix(c.GPGSig, []byte("\n"))})
+	}
+	if c.GPGSigSHA256 != nil {
+		headers = append(headers, ExtraHeader{string(gpgsigSHA256Key), bytes.TrimSuffix(c.GPGSigSHA256, []byte("\n"))})
+	}
+	return headers
+}
+
+// writeHeader writes a single header of a commit or tag.
+// Each line after the first is continued with a leading space.
+func writeHeader(b *bytes.Buffer, key keyType, value []byte) {
+	b.WriteString(string(key))
+	b.WriteByte(' ')
+	b.Write(bytes.Replace(value, []byte("\n"), []byte("\n "), -1))
+	b.WriteByte('\n')
+}
+
+// Encode returns the contents of the tree in git's canonical format,
+// without the "<type> <size>" header. The entries are written in the order
+// they appear in t.Entries, which must already be sorted the way git expects.
+func (t Tree) Encode() ([]byte, error) {
+	return t.encode()
+}
+
+// MarshalBinary is equivalent to Encode
+func (t Tree) MarshalBinary() ([]byte, error) {
+	return t.Encode()
+}
+
+// UnmarshalBinary parses the contents of a tree object (without the header).
+// The entries are expected to use SHA-1 object names.
+func (t *Tree) UnmarshalBinary(data []byte) error {
+	var err error
+	*t, err = parseTree(bytes.NewReader(data), strconv.Itoa(len(data)), SHA1)
+	return err
+}
+
+func (t Tree) encode() ([]byte, error) {
+	var b bytes.Buffer
+	for _, entry := range t.Entries {
+		if entry.Hash.IsZero() {
+			return nil, fmt.Errorf("tree entry %s has no hash", entry.Name)
+		}
+		// git does not zero-pad the mode within tree objects, but older tools did,
+		// so a mode that was parsed in another form is written the same way
+		mode := entry.Mode.treeString()
+		if raw, ok := t.rawModes[entry.Name]; ok {
+			if m, err := parseFileMode([]byte(raw)); err == nil && m == entry.Mode {
+				mode = raw
+			}
+		}
+		b.WriteString(mode)
+		b.WriteByte(' ')
+		b.WriteString(entry.Name)
+		b.WriteByte('\x00')
+		b.Write(entry.Hash.Bytes())
+	}
+	return b.Bytes(), nil
+}
+
+// Encode returns the contents of the blob, without the "<type> <size>" header.
+func (b Blob) Encode() ([]byte, error) {
+	return b.Contents, nil
+}
+
+// MarshalBinary is equivalent to Encode
+func (b Blob) MarshalBinary() ([]byte, error) {
+	return b.Encode()
+}
+
+// UnmarshalBinary sets the contents of the blob.
+func (b *Blob) UnmarshalBinary(data []byte) error {
+	*b = Blob{_type: "blob", size: strconv.Itoa(len(data)), Contents: append([]byte{}, data...)}
+	return nil
+}
+
+// Encode returns the contents of the tag in git's canonical format,
+// without the "<type> <size>" header. The signature, if any, follows the message.
+func (t Tag) Encode() ([]byte, error) {
+	var b bytes.Buffer
+	writeHeader(&b, objectKey, []byte(t.Object.String()))
+	writeHeader(&b, typeKey, []byte(t.ObjectType))
+	writeHeader(&b, tagKey, []byte(t.Tag))
+	if t.Tagger != (Signature{}) {
+		writeHeader(&b, taggerKey, []byte(t.Tagger.String()))
+	}
+	b.WriteByte('\n')
+	b.Write(t.Message)
+	b.Write(t.GPGSig)
+	return b.Bytes(), nil
+}
+
+// MarshalBinary is equivalent to Encode
+func (t Tag) MarshalBinary() ([]byte, error) {
+	return t.Encode()
+}
+
+// UnmarshalBinary parses the contents of a tag object (without the header).
+// The tag's Name is computed from the contents, using SHA-1.
+func (t *Tag) UnmarshalBinary(data []byte) error {
+	name, err := HashObject("tag", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	*t, err = parseTag(bytes.NewReader(data), strconv.Itoa(len(data)), name)
+	return err
+}
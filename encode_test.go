@@ -0,0 +1,183 @@
+package gitgo
+
+import (
+	"bytes"
+	"encoding"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// allObjects returns the name of every object in a test repository
+func allObjects(t *testing.T, repo *Repository) []SHA {
+	if err := repo.load(); err != nil {
+		t.Fatal(err)
+	}
+	var names []SHA
+	dirs, err := ioutil.ReadDir(filepath.Join(repo.Basedir.Name(), "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if len(dir.Name()) != 2 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(repo.Basedir.Name(), "objects", dir.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			names = append(names, mustSHA(dir.Name()+file.Name()))
+		}
+	}
+
+	for _, pack := range repo.packfiles {
+		for i := 0; i < pack.idx.count; i++ {
+			names = append(names, pack.idx.name(i))
+		}
+	}
+	return names
+}
+
+func Test_EncodeRoundTrip(t *testing.T) {
+	repo := &Repository{Basedir: *RepoDir}
+	names := allObjects(t, repo)
+	if len(names) == 0 {
+		t.Fatal("found no objects")
+	}
+
+	for _, name := range names {
+		obj, err := repo.Object(name)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		marshaler, ok := obj.(encoding.BinaryMarshaler)
+		if !ok {
+			t.Errorf("%s: %T cannot be encoded", name, obj)
+			continue
+		}
+		data, err := marshaler.MarshalBinary()
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		hash, err := HashObject(obj.Type(), bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if hash != name {
+			t.Errorf("%s %s was encoded as %s:\n%q", obj.Type(), name, hash, data)
+			continue
+		}
+
+		var decoded interface {
+			encoding.BinaryMarshaler
+			encoding.BinaryUnmarshaler
+		}
+		switch obj.Type() {
+		case "commit":
+			decoded = &Commit{}
+		case "tree":
+			decoded = &Tree{}
+		case "blob":
+			decoded = &Blob{}
+		case "tag":
+			decoded = &Tag{}
+		}
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		data2, err := decoded.MarshalBinary()
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !bytes.Equal(data, data2) {
+			t.Errorf("%s changed after decoding and encoding:\n%q\n%q", name, data, data2)
+		}
+	}
+}
+
+func Test_EncodeModifiedCommit(t *testing.T) {
+	repo := &Repository{Basedir: *RepoDir}
+	obj, err := repo.Object(mustSHA("97eed02ebe122df8fdd853c1215d8775f3d9f1a1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := obj.(Commit)
+	commit.Author.Email = "aditya@example.com"
+	commit.Committer.Email = "aditya@example.com"
+	commit.GPGSig = []byte("-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n-----END PGP SIGNATURE-----\n")
+
+	data, err := commit.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = "tree 9de6c72106b169990a83ce7090c7cad84b6b506b\n" +
+		"author aditya <aditya@example.com> 1428075900 -0400\n" +
+		"committer aditya <aditya@example.com> 1428075900 -0400\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n \n iQEzBAABCAAdFiEE\n -----END PGP SIGNATURE-----\n" +
+		"\nFirst commit. Create .gitignore\n"
+	if string(data) != expected {
+		t.Errorf("Expected and result don't match:\n%q\n%q", expected, data)
+	}
+
+	var decoded Commit
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.GPGSig, commit.GPGSig) {
+		t.Errorf("signature changed: %q", decoded.GPGSig)
+	}
+}
+
+func Test_EncodeUnusualOrder(t *testing.T) {
+	// The extra header comes before the encoding, and the signature before the merge tag
+	const commit = "tree 9de6c72106b169990a83ce7090c7cad84b6b506b\n" +
+		"parent 97eed02ebe122df8fdd853c1215d8775f3d9f1a1\n" +
+		"author aditya <aditya@example.com> 1428075900 -0400\n" +
+		"committer aditya <aditya@example.com> 1428075900 -0400\n" +
+		"x-extra value\n" +
+		"encoding ISO-8859-1\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n \n iQEzBAABCAAdFiEE\n -----END PGP SIGNATURE-----\n" +
+		"mergetag object 97eed02ebe122df8fdd853c1215d8775f3d9f1a1\n type commit\n tag v1\n" +
+		"\nMerge\n"
+
+	// The first entry has a zero-padded mode, as some older tools wrote
+	const tree = "040000 examples\x00" + "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xe1" +
+		"100644 file\x00" + "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xe2"
+
+	var c Commit
+	if err := c.UnmarshalBinary([]byte(commit)); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.MergeTags) != 1 || c.Encoding != "ISO-8859-1" || len(c.ExtraHeaders) != 1 {
+		t.Errorf("unexpected commit: %+v", c)
+	}
+	var tr Tree
+	if err := tr.UnmarshalBinary([]byte(tree)); err != nil {
+		t.Fatal(err)
+	}
+	for input, marshaler := range map[string]encoding.BinaryMarshaler{commit: c, tree: tr} {
+		data, err := marshaler.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != input {
+			t.Errorf("Expected and result don't match:\n%q\n%q", input, data)
+		}
+	}
+
+	// A mode that has been changed is written the usual way
+	tr.Entries[0].Mode = ModeRegular
+	data, err := tr.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("100644 examples\x00")) {
+		t.Errorf("expected the new mode and received %q", data)
+	}
+}
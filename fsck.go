@@ -0,0 +1,210 @@
+package gitgo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// ErrCorrupt is the error matched by a *CorruptObjectError
+var ErrCorrupt = errors.New("corrupt object")
+
+// CorruptObjectError is returned when an object cannot be read,
+// or its contents do not hash to its name.
+type CorruptObjectError struct {
+	// Name is the name under which the object is stored
+	Name SHA
+
+	// Actual is the hash of the object's contents,
+	// if they could be read
+	Actual SHA
+
+	// Path is the loose object file or packfile that contains the object.
+	// Offset is the position of the object within the packfile,
+	// or -1 for a loose object.
+	Path   string
+	Offset int64
+
+	// Err is the error encountered while reading the object, if any
+	Err error
+}
+
+func (e *CorruptObjectError) Error() string {
+	location := e.Path
+	if e.Offset >= 0 {
+		location = fmt.Sprintf("%s at offset %d", e.Path, e.Offset)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("object %s in %s is corrupt: %s", e.Name, location, e.Err)
+	}
+	return fmt.Sprintf("object %s in %s is corrupt: contents hash to %s", e.Name, location, e.Actual)
+}
+
+// Is allows errors.Is(err, ErrCorrupt) to match a *CorruptObjectError
+func (e *CorruptObjectError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+func (e *CorruptObjectError) Unwrap() error {
+	return e.Err
+}
+
+// checkObject hashes an object in the packfile, after any deltas
+// have been applied, and compares it to the object's name
+func (p *packfile) checkObject(obj *packObject) error {
+	corrupt := &CorruptObjectError{Name: obj.Name, Path: p.path(".pack"), Offset: int64(obj.Offset)}
+	if obj.err != nil {
+		corrupt.Err = obj.err
+		return corrupt
+	}
+
+	h := obj.Name.Format().New()
+	err := writeObject(h, obj.Type(), bytes.NewReader(obj.PatchedData), int64(len(obj.PatchedData)))
+	if err != nil {
+		corrupt.Err = err
+		return corrupt
+	}
+	if actual := newSHA(h.Sum(nil)); actual != obj.Name {
+		corrupt.Actual = actual
+		return corrupt
+	}
+	return nil
+}
+
+// Fsck checks the integrity of every loose and packed object in the repository.
+// Each object is hashed and compared to its name, regardless of r.Verify, and then parsed.
+// Every object that fails these checks, or whose compressed data does not match
+// the CRC32 in the pack index, is reported as a *CorruptObjectError.
+// If the checksums of a packfile or its index do not match, the objects are still checked,
+// and the returned error is a *PackVerifyError for the first such packfile.
+// Otherwise, the error is only set if the repository, or the structure of a packfile, could not be read.
+// Unlike `git fsck`, it does not check that the objects are connected.
+func (r *Repository) Fsck() ([]*CorruptObjectError, error) {
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*CorruptObjectError
+	report := func(err error, corrupt *CorruptObjectError) {
+		if err == nil {
+			return
+		}
+		if !errors.As(err, &corrupt) {
+			corrupt.Err = err
+		}
+		result = append(result, corrupt)
+	}
+
+	objectsDir := filepath.Join(r.Basedir.Name(), "objects")
+	dirs, err := ioutil.ReadDir(objectsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			// objects/pack and objects/info
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(objectsDir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			name, err := ParseSHA(dir.Name() + file.Name())
+			if err != nil {
+				// not an object (eg, a temporary file)
+				continue
+			}
+			filename := filepath.Join(objectsDir, dir.Name(), file.Name())
+			_, err = objectFromFile(filename, name, true)
+			report(err, &CorruptObjectError{Name: name, Path: filename, Offset: -1})
+		}
+	}
+
+	var packErr error
+	for _, pack := range r.packfiles {
+		failures, err := pack.checksums()
+		if err != nil {
+			return nil, err
+		}
+		crcs := map[int64]PackFailure{}
+		var checksums []PackFailure
+		for _, f := range failures {
+			if f.Check == CheckCRC32 {
+				crcs[f.Offset] = f
+			} else {
+				checksums = append(checksums, f)
+			}
+		}
+		if len(checksums) > 0 && packErr == nil {
+			packErr = fmt.Errorf("%s: %w", pack.path(".pack"), &PackVerifyError{Failures: checksums})
+		}
+
+		// Each object is read separately, rather than by verifying the whole packfile,
+		// so that only the delta bases in the repository's cache are kept in memory.
+		// This also resolves REF_DELTA bases that are stored outside the packfile.
+		for i := 0; i < pack.idx.count; i++ {
+			name := pack.idx.name(i)
+			offset, err := pack.idx.offset(i)
+			if err != nil {
+				return nil, err
+			}
+			corrupt := &CorruptObjectError{Name: name, Path: pack.path(".pack"), Offset: offset}
+			if f, ok := crcs[offset]; ok {
+				report(f, corrupt)
+				continue
+			}
+			obj, err := pack.object(name, offset)
+			if err == nil {
+				err = pack.checkObject(obj)
+			}
+			if err == nil {
+				_, err = obj.normalize(r.Basedir)
+			}
+			report(err, corrupt)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i].Name.Bytes(), result[j].Name.Bytes()) < 0
+	})
+	return result, packErr
+}
+
+// verifyingReader hashes the contents of an object as they are read.
+// Once the contents have been read, it reports a *CorruptObjectError
+// instead of io.EOF if they do not match the object's name.
+type verifyingReader struct {
+	r       io.Reader
+	h       hash.Hash
+	corrupt *CorruptObjectError
+}
+
+// newVerifyingReader returns a verifyingReader for the contents of an object,
+// which has the given type and size
+func newVerifyingReader(r io.Reader, objType string, size int64, corrupt *CorruptObjectError) *verifyingReader {
+	h := corrupt.Name.Format().New()
+	fmt.Fprintf(h, "%s %d\x00", objType, size)
+	return &verifyingReader{r: r, h: h, corrupt: corrupt}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF {
+		if actual := newSHA(v.h.Sum(nil)); actual != v.corrupt.Name {
+			v.corrupt.Actual = actual
+			return n, v.corrupt
+		}
+	} else if err != nil {
+		v.corrupt.Err = err
+		return n, v.corrupt
+	}
+	return n, err
+}
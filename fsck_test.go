@@ -0,0 +1,158 @@
+package gitgo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// copyRepo copies the repository in src to a temporary directory,
+// so that it can be modified. The caller is responsible for removing the directory.
+func copyRepo(t *testing.T, src string) (dir string, repo *Repository) {
+	dir, err := ioutil.TempDir("", "gitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := filepath.Join(dir, ".git")
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(gitDir, rel), 0755)
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(gitDir, rel), contents, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, &Repository{Basedir: *f}
+}
+
+func Test_Fsck(t *testing.T) {
+	for _, dir := range []*os.File{RepoDir, SHA256RepoDir} {
+		repo := Repository{Basedir: *dir}
+		corrupt, err := repo.Fsck()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range corrupt {
+			t.Errorf("unexpected corrupt object: %s", c)
+		}
+	}
+}
+
+func Test_VerifyLooseObject(t *testing.T) {
+	dir, repo := copyRepo(t, filepath.Join("test_data", "sha256", "dot_git"))
+	defer os.RemoveAll(dir)
+
+	// Replace the contents of docs/README ("hello\n") with other contents of the same size
+	name := mustSHA("2cf8d83d9ee29543b34a87727421fdecb7e3f3a183d337639025de576db9ebb4")
+	filename := loosePath(repo.Basedir.Name(), name)
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write([]byte("blob 6\x00HELLO\n"))
+	zw.Close()
+	if err := ioutil.WriteFile(filename, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := SHA256.HashObject("blob", strings.NewReader("HELLO\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without verification, the tampered contents are returned
+	if _, err := repo.Object(name); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.Verify = true
+	_, err = repo.Object(name)
+	var corrupt *CorruptObjectError
+	if !errors.As(err, &corrupt) || !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected a *CorruptObjectError and received %v", err)
+	}
+	expected := CorruptObjectError{Name: name, Actual: actual, Path: filename, Offset: -1}
+	if *corrupt != expected {
+		t.Errorf("expected %+v and received %+v", expected, *corrupt)
+	}
+
+	rc, _, err := repo.BlobReader(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(rc)
+	rc.Close()
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected a corrupt object error from BlobReader and received %v", err)
+	}
+
+	reported, err := repo.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || *reported[0] != expected {
+		t.Errorf("expected Fsck to report %+v and received %v", expected, reported)
+	}
+}
+
+func Test_VerifyPackedObject(t *testing.T) {
+	dir, repo := copyRepo(t, filepath.Join("test_data", "sha256", "dot_git"))
+	defer os.RemoveAll(dir)
+
+	// The pack index lists the names in sorted order, so changing the last
+	// byte of the last name and updating the index checksum leaves
+	// the index valid but names the object incorrectly.
+	const packName = "pack-e817bb8e68c21c6ef7592f5e26241d37575ef5caa808630aab748334ae638800"
+	idxPath := filepath.Join(repo.Basedir.Name(), "objects", "pack", packName+".idx")
+	idx, err := ioutil.ReadFile(idxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const numObjects = 11
+	lastName := 8 + 256*4 + (numObjects-1)*SHA256.Size()
+	original := newSHA(idx[lastName : lastName+SHA256.Size()])
+	idx[lastName+SHA256.Size()-1] ^= 0xff
+	tampered := newSHA(idx[lastName : lastName+SHA256.Size()])
+	h := SHA256.New()
+	h.Write(idx[:len(idx)-SHA256.Size()])
+	copy(idx[len(idx)-SHA256.Size():], h.Sum(nil))
+	if err := ioutil.WriteFile(idxPath, idx, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.Verify = true
+	_, err = repo.Object(tampered)
+	var corrupt *CorruptObjectError
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("expected a *CorruptObjectError and received %v", err)
+	}
+	if corrupt.Name != tampered || corrupt.Actual != original || corrupt.Offset <= 0 || !strings.HasSuffix(corrupt.Path, packName+".pack") {
+		t.Errorf("unexpected error: %+v", *corrupt)
+	}
+
+	reported, err := repo.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || *reported[0] != *corrupt {
+		t.Errorf("expected Fsck to report %+v and received %v", *corrupt, reported)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -24,7 +25,7 @@ func main() {
 			os.Exit(1)
 		}
 		hash := args[2]
-		result, err := gitgo.CatFile(gitgo.SHA(hash))
+		result, err := gitgo.CatFile(hash)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -35,14 +36,35 @@ func main() {
 			fmt.Println("must specify commit name with `log`")
 			os.Exit(1)
 		}
-		hash := gitgo.SHA(args[2])
+		// The commit name may be abbreviated, as it can be for cat-file
+		pwd, err := os.Open(".")
+		if err != nil {
+			log.Fatal(err)
+		}
+		repo := gitgo.Repository{Basedir: *pwd}
+		hash, err := repo.ResolvePrefix(args[2])
+		var ambiguous *gitgo.AmbiguousError
+		if errors.As(err, &ambiguous) {
+			fmt.Fprintf(os.Stderr, "gitgo: short object name %s is ambiguous\nThe candidates are:\n", ambiguous.Prefix)
+			for _, candidate := range ambiguous.Candidates {
+				if obj, err := repo.Object(candidate); err == nil {
+					fmt.Fprintf(os.Stderr, "  %s %s\n", candidate, obj.Type())
+				} else {
+					fmt.Fprintf(os.Stderr, "  %s\n", candidate)
+				}
+			}
+			os.Exit(1)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
 		commits, err := gitgo.Log(hash, nil)
 		if err != nil {
 			log.Fatal(err)
 		}
 		b := bytes.NewBuffer(nil)
 		for _, commit := range commits {
-			fmt.Fprintf(b, "commit %s\nAuthor: %s\nDate:   %s\n\n    %s\n", commit.Name, commit.Author, commit.AuthorDate.Format(gitgo.RFC2822), bytes.Replace(commit.Message, []byte("\n"), []byte("\n    "), -1))
+			fmt.Fprintf(b, "commit %s\nAuthor: %s <%s>\nDate:   %s\n\n    %s\n", commit.Name, commit.Author.Name, commit.Author.Email, commit.Author.When.Format(gitgo.RFC2822), bytes.Replace(commit.Message, []byte("\n"), []byte("\n    "), -1))
 		}
 		io.Copy(os.Stdout, b)
 	default:
@@ -0,0 +1,157 @@
+package gitgo
+
+import (
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// HashObject computes the SHA-1 name of an object with the given type and contents,
+// without writing it to a repository. It is equivalent to `git hash-object -t <type>`
+func HashObject(objType string, content io.Reader) (SHA, error) {
+	return hashObject(SHA1, objType, content)
+}
+
+// HashObject computes the name of an object with the given type and contents
+// in this object format, without writing it to a repository.
+func (f ObjectFormat) HashObject(objType string, content io.Reader) (SHA, error) {
+	return hashObject(f, objType, content)
+}
+
+func hashObject(format ObjectFormat, objType string, content io.Reader) (SHA, error) {
+	content, size, cleanup, err := sizedReader(content, "")
+	if err != nil {
+		return SHA{}, err
+	}
+	defer cleanup()
+
+	h := format.New()
+	err = writeObject(h, objType, content, size)
+	if err != nil {
+		return SHA{}, err
+	}
+	return newSHA(h.Sum(nil)), nil
+}
+
+// WriteObject stores an object with the given type and contents in the repository
+// as a loose object, and returns its name. It is equivalent to `git hash-object -w -t <type>`.
+// If the object already exists in the repository, it is not rewritten.
+func (r *Repository) WriteObject(objType string, content io.Reader) (SHA, error) {
+	err := r.load()
+	if err != nil {
+		return SHA{}, err
+	}
+	objectsDir := filepath.Join(r.Basedir.Name(), "objects")
+
+	content, size, cleanup, err := sizedReader(content, objectsDir)
+	if err != nil {
+		return SHA{}, err
+	}
+	defer cleanup()
+
+	// Write to a temporary file first, so that a partially-written
+	// object is never visible under its final name
+	tmp, err := ioutil.TempFile(objectsDir, "tmp_obj_")
+	if err != nil {
+		return SHA{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := r.format.New()
+	zw := zlib.NewWriter(tmp)
+	err = writeObject(io.MultiWriter(h, zw), objType, content, size)
+	if err != nil {
+		return SHA{}, err
+	}
+	if err = zw.Close(); err != nil {
+		return SHA{}, err
+	}
+	if err = tmp.Close(); err != nil {
+		return SHA{}, err
+	}
+	name := newSHA(h.Sum(nil))
+
+	if r.hasObject(name) {
+		return name, nil
+	}
+
+	filename := loosePath(r.Basedir.Name(), name)
+	if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return SHA{}, err
+	}
+	if err = os.Chmod(tmp.Name(), 0444); err != nil {
+		return SHA{}, err
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return SHA{}, err
+	}
+	return name, nil
+}
+
+// hasObject reports whether the object with the given (full) name
+// is stored in the repository, either as a loose object or in a packfile
+func (r *Repository) hasObject(name SHA) bool {
+	_, err := os.Stat(loosePath(r.Basedir.Name(), name))
+	if err == nil {
+		return true
+	}
+	_, _, ok, _ := r.findPacked(name)
+	return ok
+}
+
+// writeObject writes the header "<type> <size>\x00" followed by the contents of the object
+func writeObject(w io.Writer, objType string, content io.Reader, size int64) error {
+	switch objType {
+	case "commit", "tree", "blob", "tag":
+	default:
+		return fmt.Errorf("invalid object type %s", objType)
+	}
+
+	_, err := fmt.Fprintf(w, "%s %d\x00", objType, size)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(w, content)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("expected to write %d bytes and wrote %d", size, n)
+	}
+	return nil
+}
+
+// sizedReader returns a reader with the same contents as r, along with its size.
+// The object header must contain the size before the contents, so if the size cannot
+// be determined without reading r, the contents are first copied to a temporary file in dir.
+// cleanup must be called once the returned reader is no longer needed.
+func sizedReader(r io.Reader, dir string) (result io.Reader, size int64, cleanup func(), err error) {
+	cleanup = func() {}
+	if l, ok := r.(interface {
+		Len() int
+	}); ok {
+		return r, int64(l.Len()), cleanup, nil
+	}
+
+	f, err := ioutil.TempFile(dir, "tmp_spool_")
+	if err != nil {
+		return nil, 0, cleanup, err
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	size, err = io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, func() {}, err
+	}
+	return f, size, cleanup, nil
+}
@@ -0,0 +1,118 @@
+package gitgo
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tempRepo creates an empty repository in a temporary directory.
+// The caller is responsible for removing the directory.
+func tempRepo(t *testing.T) (dir string, repo *Repository) {
+	dir, err := ioutil.TempDir("", "gitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := filepath.Join(dir, ".git")
+	for _, d := range []string{"objects/pack", "objects/info", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(gitDir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, &Repository{Basedir: *f}
+}
+
+func Test_HashObject(t *testing.T) {
+	expected := mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")
+	const contents = "*.swp\n*.swo\n*.swn\n"
+
+	// strings.Reader reports its length, but the wrapped reader does not
+	for _, r := range []io.Reader{strings.NewReader(contents), withoutLen(contents)} {
+		name, err := HashObject("blob", r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != expected {
+			t.Errorf("expected %s and received %s", expected, name)
+		}
+	}
+
+	if _, err := HashObject("bogus", strings.NewReader(contents)); err == nil {
+		t.Errorf("expected an error for an invalid object type")
+	}
+}
+
+func Test_WriteObject(t *testing.T) {
+	dir, repo := tempRepo(t)
+	defer os.RemoveAll(dir)
+
+	const contents = "*.swp\n*.swo\n*.swn\n"
+	name, err := repo.WriteObject("blob", withoutLen(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67") {
+		t.Errorf("received incorrect name %s", name)
+	}
+
+	obj, err := repo.Object(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(obj.(Blob).Contents, []byte(contents)) {
+		t.Errorf("received incorrect contents %q", obj.(Blob).Contents)
+	}
+
+	// the written object must match the one written by git
+	written, err := objectFromFile(filepath.Join(dir, ".git", "objects", "af", "6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"), name, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := objectFromFile(filepath.Join(RepoDir.Name(), "objects", "af", "6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"), name, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, orig) {
+		t.Errorf("written object does not match:\n%+v\n%+v", written, orig)
+	}
+
+	// writing the same object again should leave the existing file in place
+	filename := filepath.Join(dir, ".git", "objects", "af", "6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67")
+	before, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.WriteObject("blob", strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Errorf("existing object was rewritten")
+	}
+
+	// no temporary files should be left behind
+	files, err := filepath.Glob(filepath.Join(dir, ".git", "objects", "tmp_*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("temporary files left behind: %v", files)
+	}
+}
+
+// withoutLen returns a reader that does not report its length
+func withoutLen(s string) io.Reader {
+	return struct{ io.Reader }{strings.NewReader(s)}
+}
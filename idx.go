@@ -0,0 +1,284 @@
+package gitgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+// idxMagic is the first four bytes of a version 2 pack index.
+// Version 1 indexes have no header, but they begin with a fanout table,
+// and no valid fanout table can begin with these bytes.
+var idxMagic = []byte{255, 116, 79, 99}
+
+// packIndex is the contents of a pack index (.idx) file, which lists
+// the names of the objects in a packfile in sorted order, along with
+// the offset of each object within the packfile.
+//
+// A version 2 index is laid out as:
+//
+//	magic number and version (8 bytes)
+//	fanout table (256 4-byte entries)
+//	object names (count entries of 20 or 32 bytes)
+//	CRC32s of the compressed objects (count 4-byte entries)
+//	offsets (count 4-byte entries)
+//	large offsets (8-byte entries, for offsets that do not fit in 31 bits)
+//	checksum of the packfile, and checksum of the index
+//
+// A version 1 index has no header, CRC32s, or large offsets.
+// Each offset is stored alongside the object's name:
+//
+//	fanout table (256 4-byte entries)
+//	offsets and names (count entries of 4 bytes followed by 20 or 32 bytes)
+//	checksum of the packfile, and checksum of the index
+type packIndex struct {
+	version int
+	format  ObjectFormat
+	count   int
+
+	// names and offsets are tables with one entry per object,
+	// and the entries of each table are stride bytes apart
+	fanout       []byte
+	names        []byte
+	offsets      []byte
+	stride       int
+	offsetStride int
+
+	// crcs and largeOffsets are only present in version 2.
+	// largeOffsets holds the offsets that do not fit in 31 bits,
+	// for packfiles larger than 2 GB
+	crcs         []byte
+	largeOffsets []byte
+	trailer      []byte
+}
+
+// readPackIndex reads the pack index in the given file
+func readPackIndex(filename string, format ObjectFormat) (*packIndex, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parsePackIndex(data, format)
+}
+
+// parsePackIndex parses the contents of a version 1 or version 2 pack index,
+// checking that each of its tables is present. The index retains data.
+func parsePackIndex(data []byte, format ObjectFormat) (*packIndex, error) {
+	hashSize := format.Size()
+	idx := &packIndex{version: 1, format: format}
+	if len(data) >= 4 && bytes.Equal(data[:4], idxMagic) {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("IDX is too short: %d bytes", len(data))
+		}
+		if version := binary.BigEndian.Uint32(data[4:8]); version != 2 {
+			return nil, fmt.Errorf("cannot parse IDX with version %d", version)
+		}
+		idx.version = 2
+		data = data[8:]
+	}
+
+	if len(data) < 256*4 {
+		return nil, fmt.Errorf("read incomplete fanout table: %d", len(data))
+	}
+	idx.fanout = data[:256*4]
+	idx.count = int(binary.BigEndian.Uint32(idx.fanout[255*4:]))
+	data = data[256*4:]
+
+	// Each fanout entry counts the objects whose first byte is at most i,
+	// so the entries can never decrease
+	var previous uint32
+	for i := 0; i < 256; i++ {
+		n := binary.BigEndian.Uint32(idx.fanout[i*4:])
+		if n < previous {
+			return nil, fmt.Errorf("invalid fanout table: entry %d is smaller than entry %d", i, i-1)
+		}
+		previous = n
+	}
+
+	// Every table except the large offsets has one entry per object
+	tableSize := idx.count * (hashSize + 4)
+	if idx.version == 2 {
+		tableSize += idx.count * 4
+	}
+	if len(data) < tableSize+2*hashSize {
+		return nil, fmt.Errorf("IDX is too short for %d objects", idx.count)
+	}
+
+	if idx.version == 1 {
+		entries := data[:tableSize]
+		idx.offsets, idx.offsetStride = entries, 4+hashSize
+		idx.names, idx.stride = entries[4:], 4+hashSize
+		idx.trailer = data[tableSize:]
+		if len(idx.trailer) != 2*hashSize {
+			return nil, fmt.Errorf("invalid IDX: found %d bytes after the last object", len(idx.trailer)-2*hashSize)
+		}
+		return idx, nil
+	}
+
+	idx.names, data = data[:idx.count*hashSize], data[idx.count*hashSize:]
+	idx.stride = hashSize
+	idx.crcs, data = data[:idx.count*4], data[idx.count*4:]
+	idx.offsets, data = data[:idx.count*4], data[idx.count*4:]
+	idx.offsetStride = 4
+	idx.largeOffsets = data[:len(data)-2*hashSize]
+	idx.trailer = data[len(data)-2*hashSize:]
+	if len(idx.largeOffsets)%8 != 0 {
+		return nil, fmt.Errorf("invalid IDX: large offset table is %d bytes long", len(idx.largeOffsets))
+	}
+	return idx, nil
+}
+
+// nameBytes returns the raw name of the i-th object in the index
+func (idx *packIndex) nameBytes(i int) []byte {
+	return idx.names[i*idx.stride : i*idx.stride+idx.format.Size()]
+}
+
+// name returns the name of the i-th object in the index
+func (idx *packIndex) name(i int) SHA {
+	return newSHA(idx.nameBytes(i))
+}
+
+// offset returns the offset of the i-th object within the packfile
+func (idx *packIndex) offset(i int) (int64, error) {
+	offset := binary.BigEndian.Uint32(idx.offsets[i*idx.offsetStride:])
+	if offset&0x80000000 == 0 || idx.version == 1 {
+		return int64(offset), nil
+	}
+
+	// If the MSB is set, the other 31 bits are an index
+	// into the table of 8-byte offsets
+	large := int(offset & 0x7fffffff)
+	if large >= len(idx.largeOffsets)/8 {
+		return 0, fmt.Errorf("invalid IDX: large offset %d for object %s is out of range", large, idx.name(i))
+	}
+	result := binary.BigEndian.Uint64(idx.largeOffsets[large*8:])
+	if result > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid IDX: offset %d for object %s is out of range", result, idx.name(i))
+	}
+	return int64(result), nil
+}
+
+// crc32 returns the CRC32 of the i-th object's compressed data.
+// Version 1 indexes do not contain CRC32s.
+func (idx *packIndex) crc32(i int) (uint32, bool) {
+	if idx.crcs == nil {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(idx.crcs[i*4:]), true
+}
+
+// packChecksum returns the checksum of the packfile that the index was written for
+func (idx *packIndex) packChecksum() []byte {
+	return idx.trailer[:idx.format.Size()]
+}
+
+// checksum returns the checksum of the index itself
+func (idx *packIndex) checksum() []byte {
+	return idx.trailer[idx.format.Size():]
+}
+
+// objects returns the objects listed in the index, in the order in which they are listed.
+// None of the objects are read from the packfile.
+func (idx *packIndex) objects() ([]*packObject, error) {
+	objects := make([]*packObject, idx.count)
+	for i := range objects {
+		offset, err := idx.offset(i)
+		if err != nil {
+			return nil, err
+		}
+		objects[i] = &packObject{Name: idx.name(i), Offset: int(offset)}
+	}
+	return objects, nil
+}
+
+// bucket returns the range of positions of the objects whose names begin with the given byte
+func (idx *packIndex) bucket(b byte) (start, end int) {
+	if b > 0 {
+		start = int(binary.BigEndian.Uint32(idx.fanout[(int(b)-1)*4:]))
+	}
+	end = int(binary.BigEndian.Uint32(idx.fanout[int(b)*4:]))
+	return start, end
+}
+
+// find returns the position of the object with the given name.
+// The fanout table narrows the search to objects that share the first byte
+// of the name, which are then binary searched.
+func (idx *packIndex) find(name SHA) (int, bool) {
+	target := name.Bytes()
+	if len(target) != idx.format.Size() {
+		return 0, false
+	}
+	start, end := idx.bucket(target[0])
+	i := start + sort.Search(end-start, func(i int) bool {
+		return bytes.Compare(idx.nameBytes(start+i), target) >= 0
+	})
+	if i < end && bytes.Equal(idx.nameBytes(i), target) {
+		return i, true
+	}
+	return 0, false
+}
+
+// indexEntry is the information about a single object that
+// is recorded in a pack index
+type indexEntry struct {
+	name   SHA
+	offset int64
+	crc32  uint32
+}
+
+// writePackIndex writes a version 2 pack index for the given entries,
+// in the given format, to w. The entries are sorted by name.
+// packChecksum is the checksum at the end of the packfile.
+func writePackIndex(w io.Writer, format ObjectFormat, entries []indexEntry, packChecksum []byte) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].name.Bytes(), entries[j].name.Bytes()) < 0
+	})
+
+	var buf bytes.Buffer
+	buf.Write(idxMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+
+	var fanout [256]uint32
+	for _, entry := range entries {
+		fanout[entry.name.Bytes()[0]]++
+	}
+	var total uint32
+	for i := range fanout {
+		total += fanout[i]
+		fanout[i] = total
+	}
+	binary.Write(&buf, binary.BigEndian, fanout)
+
+	for i, entry := range entries {
+		if i > 0 && entry.name == entries[i-1].name {
+			return fmt.Errorf("duplicate object in pack index: %s", entry.name)
+		}
+		buf.Write(entry.name.Bytes())
+	}
+	for _, entry := range entries {
+		binary.Write(&buf, binary.BigEndian, entry.crc32)
+	}
+
+	// Offsets that do not fit in 31 bits are stored in the table of large offsets
+	var largeOffsets []uint64
+	for _, entry := range entries {
+		if entry.offset < 0x80000000 {
+			binary.Write(&buf, binary.BigEndian, uint32(entry.offset))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(len(largeOffsets))|0x80000000)
+		largeOffsets = append(largeOffsets, uint64(entry.offset))
+	}
+	binary.Write(&buf, binary.BigEndian, largeOffsets)
+	buf.Write(packChecksum)
+
+	h := format.New()
+	h.Write(buf.Bytes())
+	buf.Write(h.Sum(nil))
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,241 @@
+package gitgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_PackIndexFind(t *testing.T) {
+	repo := Repository{Basedir: *RepoDir}
+	if err := repo.load(); err != nil {
+		t.Fatal(err)
+	}
+	pack := repo.packfiles[0]
+
+	packFile := mustOpen(t, pack.path(".pack"))
+	defer packFile.Close()
+	idxFile := mustOpen(t, pack.path(".idx"))
+	defer idxFile.Close()
+	objects, err := verifyPack(packFile, idxFile, pack.format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != pack.idx.count {
+		t.Fatalf("expected %d objects and received %d", pack.idx.count, len(objects))
+	}
+
+	// Reading an object lazily, including resolving its delta chain,
+	// must give the same result as reading the entire packfile
+	for _, expected := range objects {
+		offset, ok, err := pack.find(expected.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("object %s not found", expected.Name)
+			continue
+		}
+		if offset != int64(expected.Offset) {
+			t.Errorf("expected offset %d and received %d for %s", expected.Offset, offset, expected.Name)
+		}
+		obj, err := pack.object(expected.Name, offset)
+		if err != nil {
+			t.Errorf("could not read %s: %s", expected.Name, err)
+			continue
+		}
+		if obj.Type() != expected.Type() || !bytes.Equal(obj.PatchedData, expected.PatchedData) {
+			t.Errorf("object %s does not match the result of VerifyPack", expected.Name)
+		}
+	}
+
+	for _, missing := range []SHA{mustSHA("0000000000000000000000000000000000000000"), mustSHA("ffffffffffffffffffffffffffffffffffffffff"), mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d68")} {
+		if _, ok, _ := pack.find(missing); ok {
+			t.Errorf("expected %s not to be found", missing)
+		}
+	}
+}
+
+func Test_parsePackIndexInvalid(t *testing.T) {
+	data, err := ioutil.ReadFile(path.Join(RepoDir.Name(), "objects/pack/pack-d310969c4ba0ebfe725685fa577a1eec5ecb15b2.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsePackIndex(data, SHA1); err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := data[:len(data)-41]
+	if _, err := parsePackIndex(truncated, SHA1); err == nil {
+		t.Errorf("expected an error for a truncated index")
+	}
+
+	decreasing := append([]byte{}, data...)
+	decreasing[8+3] = 0xff
+	if _, err := parsePackIndex(decreasing, SHA1); err == nil {
+		t.Errorf("expected an error for a decreasing fanout table")
+	}
+}
+
+func Test_PackIndexLargeOffsets(t *testing.T) {
+	// This index was written by `git index-pack --index-version=2,0`,
+	// which stores every offset in the 8-byte offset table
+	const packName = "pack-d310969c4ba0ebfe725685fa577a1eec5ecb15b2"
+	largePath := path.Join("test_data", "large-offsets", packName+".idx")
+	data, err := ioutil.ReadFile(largePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := parsePackIndex(data, SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := readPackIndex(path.Join(RepoDir.Name(), "objects", "pack", packName+".idx"), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(large.largeOffsets) != 8*large.count {
+		t.Fatalf("expected %d large offsets and received %d", large.count, len(large.largeOffsets)/8)
+	}
+	for i := 0; i < small.count; i++ {
+		expected, err := small.offset(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset, err := large.offset(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if large.name(i) != small.name(i) || offset != expected {
+			t.Errorf("expected %s at %d and received %s at %d", small.name(i), expected, large.name(i), offset)
+		}
+	}
+
+	// Both VerifyPack and lookups through a repository should use the large offsets
+	packFile, err := os.Open(path.Join(RepoDir.Name(), "objects", "pack", packName+".pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packFile.Close()
+	idxFile, err := os.Open(largePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idxFile.Close()
+	objects, err := VerifyPack(packFile, idxFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objects {
+		if obj.err != nil {
+			t.Errorf("error reading %s: %s", obj.Name, obj.err)
+		}
+	}
+
+	dir, repo := copyRepo(t, path.Join("test_data", "dot_git"))
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(path.Join(repo.Basedir.Name(), "objects", "pack", packName+".idx"), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// c3b8133 is at the end of a delta chain
+	obj, err := repo.Object(mustSHA("c3b8133617bbdb72e237b0f163fade7fbf1f0c18"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Type() != "blob" {
+		t.Errorf("expected a blob and received a %s", obj.Type())
+	}
+
+	// Offsets beyond 4 GB must not be truncated
+	binary.BigEndian.PutUint64(large.largeOffsets, 6<<30)
+	offset, err := large.offset(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 6<<30 {
+		t.Errorf("expected offset %d and received %d", int64(6<<30), offset)
+	}
+
+	binary.BigEndian.PutUint32(large.offsets, 0x80000000|uint32(large.count))
+	if _, err := large.offset(0); err == nil {
+		t.Errorf("expected an error for an out-of-range large offset")
+	}
+}
+
+func Test_PackIndexV1(t *testing.T) {
+	// This index was written by `git index-pack --index-version=1`
+	const packName = "pack-d310969c4ba0ebfe725685fa577a1eec5ecb15b2"
+	v1Path := path.Join("test_data", "idx-v1", packName+".idx")
+	v1, err := readPackIndex(v1Path, SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := readPackIndex(path.Join(RepoDir.Name(), "objects", "pack", packName+".idx"), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.version != 1 || v2.version != 2 {
+		t.Fatalf("expected versions 1 and 2 and received %d and %d", v1.version, v2.version)
+	}
+	if v1.count != v2.count {
+		t.Fatalf("expected %d objects and received %d", v2.count, v1.count)
+	}
+	for i := 0; i < v2.count; i++ {
+		expected, err := v2.offset(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset, err := v1.offset(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v1.name(i) != v2.name(i) || offset != expected {
+			t.Errorf("expected %s at %d and received %s at %d", v2.name(i), expected, v1.name(i), offset)
+		}
+		if j, ok := v1.find(v2.name(i)); !ok || j != i {
+			t.Errorf("expected to find %s at %d and received %d (%t)", v2.name(i), i, j, ok)
+		}
+	}
+
+	packFile, err := os.Open(path.Join(RepoDir.Name(), "objects", "pack", packName+".pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packFile.Close()
+	idxFile, err := os.Open(v1Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idxFile.Close()
+	objects, err := VerifyPack(packFile, idxFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objects {
+		if obj.err != nil {
+			t.Errorf("error reading %s: %s", obj.Name, obj.err)
+		}
+	}
+
+	dir, repo := copyRepo(t, path.Join("test_data", "dot_git"))
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile(v1Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(repo.Basedir.Name(), "objects", "pack", packName+".idx"), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := repo.ResolvePrefix("c3b8133")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Object(name); err != nil {
+		t.Fatal(err)
+	}
+}
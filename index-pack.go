@@ -0,0 +1,319 @@
+package gitgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// indexedEntry is an entry that has been read from a packfile
+// that is being indexed
+type indexedEntry struct {
+	offset int64
+	crc32  uint32
+
+	// _type is the type of the entry, and data is its inflated contents,
+	// which are a delta if it is an OFS_DELTA or REF_DELTA
+	_type      packObjectType
+	data       []byte
+	baseOffset int64
+	baseName   SHA
+
+	// name, objType, and contents are set once any deltas have been resolved
+	name     SHA
+	objType  packObjectType
+	contents []byte
+
+	// external is set for a delta base that was read from the repository
+	// to complete a thin pack. It is dropped if the packfile turns out to
+	// contain the same object after all.
+	external bool
+	dropped  bool
+}
+
+// IndexPack reads an entire packfile from pack, and writes the corresponding
+// version 2 index to idx. It is equivalent to `git index-pack`.
+// Every entry is inflated and every delta is resolved, in order to compute
+// the name of each object, so the packfile does not need to be indexed already.
+// It returns the checksum of the packfile, which git uses to name it.
+//
+// The packfile must name its objects using SHA-1, and every delta base must be
+// contained in the packfile; use Repository.FixThinPack to index a thin pack.
+// The packfile, and every object in it, is held in memory while it is indexed.
+func IndexPack(pack io.Reader, idx io.Writer) (SHA, error) {
+	data, err := ioutil.ReadAll(pack)
+	if err != nil {
+		return SHA{}, err
+	}
+	entries, err := readPackEntries(data, SHA1)
+	if err != nil {
+		return SHA{}, err
+	}
+	if _, err := resolveEntries(entries, SHA1, nil); err != nil {
+		return SHA{}, err
+	}
+	checksum := data[len(data)-SHA1.Size():]
+	return newSHA(checksum), writePackIndex(idx, SHA1, indexEntries(entries), checksum)
+}
+
+// FixThinPack is like IndexPack, but reads a packfile that names its objects
+// using the repository's object format, and which may be thin: it may contain
+// REF_DELTA entries whose bases are not in the packfile, as `git fetch` receives.
+// Those bases are read from the repository and appended to the packfile,
+// so that it can be read on its own. The completed packfile is written to out,
+// and its index to idx. It is equivalent to `git index-pack --fix-thin`.
+// If the packfile is not thin, it is written to out unchanged.
+func (r *Repository) FixThinPack(pack io.Reader, out, idx io.Writer) (SHA, error) {
+	err := r.load()
+	if err != nil {
+		return SHA{}, err
+	}
+	data, err := ioutil.ReadAll(pack)
+	if err != nil {
+		return SHA{}, err
+	}
+	entries, err := readPackEntries(data, r.format)
+	if err != nil {
+		return SHA{}, err
+	}
+	externals, err := resolveEntries(entries, r.format, r)
+	if err != nil {
+		return SHA{}, err
+	}
+	data, entries, err = appendBases(data, entries, externals, r.format)
+	if err != nil {
+		return SHA{}, err
+	}
+
+	if _, err := out.Write(data); err != nil {
+		return SHA{}, err
+	}
+	checksum := data[len(data)-r.format.Size():]
+	return newSHA(checksum), writePackIndex(idx, r.format, indexEntries(entries), checksum)
+}
+
+// readPackEntries reads every entry in the packfile in order, from its header
+// to its trailing checksum, which must match the rest of the packfile.
+// Each entry is inflated, but deltas are not resolved.
+func readPackEntries(data []byte, format ObjectFormat) ([]*indexedEntry, error) {
+	hashSize := format.Size()
+	if len(data) < 12+hashSize {
+		return nil, fmt.Errorf("packfile is too short: %d bytes", len(data))
+	}
+	if string(data[:4]) != "PACK" {
+		return nil, fmt.Errorf("Received invalid signature: %s", string(data[:4]))
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != 2 && version != 3 {
+		return nil, fmt.Errorf("cannot parse packfile with version %d", version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	content := data[:len(data)-hashSize]
+	h := format.New()
+	h.Write(content)
+	if !bytes.Equal(h.Sum(nil), data[len(content):]) {
+		return nil, fmt.Errorf("packfile checksum does not match its contents")
+	}
+
+	var entries []*indexedEntry
+	r := bytes.NewReader(content[12:])
+	for i := uint32(0); i < count; i++ {
+		offset := int64(len(content) - r.Len())
+		_type, size, err := readPackObjectHeader(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading entry %d of %d at offset %d: %s", i+1, count, offset, err)
+		}
+		entry := &indexedEntry{offset: offset, _type: _type}
+		switch _type {
+		case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+		case OBJ_OFS_DELTA:
+			distance, err := readOffsetDelta(r)
+			if err != nil {
+				return nil, err
+			}
+			if distance <= 0 || distance > offset {
+				return nil, fmt.Errorf("invalid delta base offset %d for entry at offset %d", distance, offset)
+			}
+			entry.baseOffset = offset - distance
+		case OBJ_REF_DELTA:
+			name := make([]byte, hashSize)
+			if _, err := io.ReadFull(r, name); err != nil {
+				return nil, err
+			}
+			entry.baseName = newSHA(name)
+		default:
+			return nil, fmt.Errorf("invalid object type %d at offset %d", _type, offset)
+		}
+
+		entry.data, err = inflateEntry(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("inflating entry at offset %d: %s", offset, err)
+		}
+		entry.crc32 = crc32.ChecksumIEEE(content[offset : len(content)-r.Len()])
+		entries = append(entries, entry)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("found %d bytes after the last object", r.Len())
+	}
+	return entries, nil
+}
+
+// inflateEntry decompresses the zlib stream at the start of r, which must
+// expand to exactly size bytes. Since bytes.Reader is an io.ByteReader,
+// the decompressor does not read past the end of the stream,
+// so r is left at the start of the next entry.
+func inflateEntry(r *bytes.Reader, size int) ([]byte, error) {
+	return inflate(r, size)
+}
+
+// resolveEntries applies every delta in the packfile, starting from the entries
+// that are stored whole, and computes the name of each object.
+// If repo is non-nil, any REF_DELTA bases that are not in the packfile
+// are read from the repository, and returned so that they can be appended.
+func resolveEntries(entries []*indexedEntry, format ObjectFormat, repo *Repository) ([]*indexedEntry, error) {
+	byOffset := map[int64]*indexedEntry{}
+	for _, entry := range entries {
+		byOffset[entry.offset] = entry
+	}
+
+	// Deltas are resolved once their base has been resolved,
+	// so record the deltas that depend on each base
+	ofsDeltas := map[int64][]*indexedEntry{}
+	refDeltas := map[SHA][]*indexedEntry{}
+	var resolved []*indexedEntry
+	for _, entry := range entries {
+		switch entry._type {
+		case OBJ_OFS_DELTA:
+			if _, ok := byOffset[entry.baseOffset]; !ok {
+				return nil, fmt.Errorf("delta base for entry at offset %d is not the start of an entry: %d", entry.offset, entry.baseOffset)
+			}
+			ofsDeltas[entry.baseOffset] = append(ofsDeltas[entry.baseOffset], entry)
+		case OBJ_REF_DELTA:
+			refDeltas[entry.baseName] = append(refDeltas[entry.baseName], entry)
+		default:
+			entry.objType, entry.contents = entry._type, entry.data
+			resolved = append(resolved, entry)
+		}
+	}
+
+	names := map[SHA]*indexedEntry{}
+	var externals []*indexedEntry
+	unresolved := len(entries)
+	for {
+		for len(resolved) > 0 {
+			base := resolved[len(resolved)-1]
+			resolved = resolved[:len(resolved)-1]
+
+			h := format.New()
+			writeObject(h, base.objType.objectType(), bytes.NewReader(base.contents), int64(len(base.contents)))
+			base.name = newSHA(h.Sum(nil))
+			if other, ok := names[base.name]; ok {
+				if !other.external {
+					return nil, fmt.Errorf("packfile contains %s more than once", base.name)
+				}
+				other.dropped = true
+			}
+			names[base.name] = base
+			if !base.external {
+				unresolved--
+			}
+
+			deltas := refDeltas[base.name]
+			delete(refDeltas, base.name)
+			if !base.external {
+				deltas = append(deltas, ofsDeltas[base.offset]...)
+			}
+			for _, delta := range deltas {
+				patched, err := patchDelta(bytes.NewReader(base.contents), bytes.NewReader(delta.data))
+				if err != nil {
+					return nil, fmt.Errorf("applying delta at offset %d: %s", delta.offset, err)
+				}
+				delta.contents, err = ioutil.ReadAll(patched)
+				if err != nil {
+					return nil, err
+				}
+				delta.objType = base.objType
+				resolved = append(resolved, delta)
+			}
+		}
+		if unresolved == 0 {
+			return externals, nil
+		}
+
+		// The remaining deltas depend on bases that are not in the packfile
+		missing := make([]SHA, 0, len(refDeltas))
+		for name := range refDeltas {
+			missing = append(missing, name)
+		}
+		if len(missing) == 0 {
+			return nil, fmt.Errorf("%d deltas could not be resolved", unresolved)
+		}
+		sort.Slice(missing, func(i, j int) bool {
+			return bytes.Compare(missing[i].Bytes(), missing[j].Bytes()) < 0
+		})
+		if repo == nil {
+			return nil, fmt.Errorf("delta base not in packfile: %s", missing[0])
+		}
+
+		// Read one base at a time, since the others may be
+		// objects in the packfile that depend on it.
+		// A base that the repository has, but cannot be read, is an error.
+		for _, name := range missing {
+			if !repo.hasObject(name) {
+				continue
+			}
+			_type, data, err := repo.readObject(name)
+			if err != nil {
+				return nil, err
+			}
+			external := &indexedEntry{external: true, objType: _type, contents: data}
+			externals = append(externals, external)
+			resolved = append(resolved, external)
+			break
+		}
+		if len(resolved) == 0 {
+			return nil, fmt.Errorf("delta base not found: %s", missing[0])
+		}
+	}
+}
+
+// appendBases appends the delta bases that were read from the repository
+// to the packfile as whole objects, and updates the object count and checksum
+func appendBases(data []byte, entries, externals []*indexedEntry, format ObjectFormat) ([]byte, []*indexedEntry, error) {
+	if len(externals) == 0 {
+		return data, entries, nil
+	}
+	buf := bytes.NewBuffer(append([]byte(nil), data[:len(data)-format.Size()]...))
+	for _, external := range externals {
+		if external.dropped {
+			continue
+		}
+		external.offset = int64(buf.Len())
+		header := appendEntryHeader(nil, external.objType, len(external.contents))
+		var err error
+		external.crc32, err = writeCompressedEntry(buf, header, external.contents)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, external)
+	}
+
+	fixed := buf.Bytes()
+	binary.BigEndian.PutUint32(fixed[8:12], uint32(len(entries)))
+	h := format.New()
+	h.Write(fixed)
+	return h.Sum(fixed), entries, nil
+}
+
+// indexEntries returns the information about each entry that is recorded in the index
+func indexEntries(entries []*indexedEntry) []indexEntry {
+	result := make([]indexEntry, len(entries))
+	for i, entry := range entries {
+		result[i] = indexEntry{name: entry.name, offset: entry.offset, crc32: entry.crc32}
+	}
+	return result
+}
@@ -0,0 +1,132 @@
+package gitgo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readPack returns the contents of a packfile in the repository, and of its index
+func readPack(t *testing.T, dir *os.File, name string) (pack, idx []byte) {
+	base := filepath.Join(dir.Name(), "objects", "pack", name)
+	pack, err := ioutil.ReadFile(base + ".pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err = ioutil.ReadFile(base + ".idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pack, idx
+}
+
+func Test_IndexPack(t *testing.T) {
+	// The second packfile contains REF_DELTA entries, whose bases are in the same packfile
+	cases := []struct {
+		dir  *os.File
+		name string
+	}{
+		{RepoDir, "pack-d310969c4ba0ebfe725685fa577a1eec5ecb15b2"},
+		{RefDeltaRepoDir, "pack-fe348c216ae0e203daa9f346aa06ee17615e5444"},
+	}
+	for _, c := range cases {
+		pack, expected := readPack(t, c.dir, c.name)
+		var idx bytes.Buffer
+		checksum, err := IndexPack(bytes.NewReader(pack), &idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if "pack-"+checksum.String() != c.name {
+			t.Errorf("expected checksum %s and received %s", c.name, checksum)
+		}
+		// The index is identical to the one written by git
+		if !bytes.Equal(idx.Bytes(), expected) {
+			t.Errorf("index for %s does not match", c.name)
+		}
+	}
+}
+
+func Test_IndexPackSHA256(t *testing.T) {
+	const name = "pack-e817bb8e68c21c6ef7592f5e26241d37575ef5caa808630aab748334ae638800"
+	pack, expected := readPack(t, SHA256RepoDir, name)
+	repo := Repository{Basedir: *SHA256RepoDir}
+	var out, idx bytes.Buffer
+	if _, err := repo.FixThinPack(bytes.NewReader(pack), &out, &idx); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), pack) {
+		t.Errorf("expected a complete packfile to be unchanged")
+	}
+	if !bytes.Equal(idx.Bytes(), expected) {
+		t.Errorf("index for %s does not match", name)
+	}
+}
+
+func Test_FixThinPack(t *testing.T) {
+	repo := Repository{Basedir: *RefDeltaRepoDir}
+	for _, name := range []string{"pack-afe6da812e43c6acd0587a67da7e2f2360cd9403", "pack-bf789cfabf1edfb813df55daeb13b876d37f4c34"} {
+		pack, _ := readPack(t, RefDeltaRepoDir, name)
+		if _, err := IndexPack(bytes.NewReader(pack), ioutil.Discard); err == nil {
+			t.Errorf("expected an error indexing thin pack %s", name)
+		}
+
+		var out, idx bytes.Buffer
+		_, err := repo.FixThinPack(bytes.NewReader(pack), &out, &idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The completed packfile contains the missing base, so it can be read on its own
+		objects, err := VerifyPack(bytes.NewReader(out.Bytes()), bytes.NewReader(idx.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, thinIdx := readPack(t, RefDeltaRepoDir, name)
+		thin, err := parsePackIndex(thinIdx, SHA1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objects) != thin.count+1 {
+			t.Errorf("expected %d objects and received %d", thin.count+1, len(objects))
+		}
+	}
+}
+
+func Test_FixThinPackCorruptBase(t *testing.T) {
+	// The base of the thin pack is the loose object d9d199c,
+	// which is replaced with data that is not zlib-compressed
+	dir, repo := copyRepo(t, filepath.Join("test_data", "ref-delta", "dot_git"))
+	defer os.RemoveAll(dir)
+	base := mustSHA("d9d199cb5da0000deff17cc5dcac76a17e682384")
+	if err := ioutil.WriteFile(loosePath(repo.Basedir.Name(), base), []byte("not an object"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pack, _ := readPack(t, RefDeltaRepoDir, "pack-bf789cfabf1edfb813df55daeb13b876d37f4c34")
+	if _, err := repo.FixThinPack(bytes.NewReader(pack), ioutil.Discard, ioutil.Discard); err != zlib.ErrHeader {
+		t.Errorf("expected %v and received %v", zlib.ErrHeader, err)
+	}
+}
+
+func Test_IndexPackInvalid(t *testing.T) {
+	pack, _ := readPack(t, RepoDir, "pack-d310969c4ba0ebfe725685fa577a1eec5ecb15b2")
+
+	corrupt := append([]byte(nil), pack...)
+	corrupt[1010] ^= 0xff
+	truncated := append([]byte(nil), pack[:len(pack)/2]...)
+
+	// An object is corrupt, but the checksum of the packfile matches
+	rehashed := append([]byte(nil), corrupt...)
+	h := SHA1.New()
+	h.Write(rehashed[:len(rehashed)-SHA1.Size()])
+	copy(rehashed[len(rehashed)-SHA1.Size():], h.Sum(nil))
+
+	for _, invalid := range [][]byte{nil, pack[:12], corrupt, truncated, rehashed} {
+		if _, err := IndexPack(bytes.NewReader(invalid), ioutil.Discard); err == nil {
+			t.Errorf("expected an error for invalid packfile of %d bytes", len(invalid))
+		}
+	}
+}
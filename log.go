@@ -3,7 +3,6 @@ package gitgo
 import (
 	"fmt"
 	"os"
-	"reflect"
 )
 
 // Log is equivalent to `git log <SHA>`. If basedir is non-nil
@@ -17,63 +16,98 @@ func Log(name SHA, basedir *os.File) ([]Commit, error) {
 	defer dir.Close()
 
 	repo := Repository{Basedir: *dir}
-	as, err := repo.allAncestors(name)
-	if err != nil {
-		return nil, err
-	}
-
 	obj, err := repo.Object(name)
 	if err != nil {
 		return nil, fmt.Errorf("commit not found: %s", err)
 	}
-	result := make([]Commit, len(as)+1)
-	result[0] = obj.(Commit)
-	for i, o := range as {
-		result[i+1] = o
+	commit, ok := obj.(Commit)
+	if !ok {
+		return nil, fmt.Errorf("not a commit: %s (%s)", name, obj.Type())
 	}
-	return result, nil
-}
-
-func (r *Repository) allAncestors(name SHA) ([]Commit, error) {
-	basedir := r.Basedir
-	obj, err := r.Object(name)
+	as, err := repo.allAncestors(commit)
 	if err != nil {
-		return nil, fmt.Errorf("commit not found: %s", err)
+		return nil, err
 	}
+	return append([]Commit{commit}, as...), nil
+}
 
-	var commit Commit
-	switch obj := obj.(type) {
-	case *packObject:
-		// TODO check that this case is logically possible
-		commit, err = obj.Commit(basedir)
+// allAncestors returns the first-parent ancestors of the commit, nearest first.
+// Each ancestor is read once, as it is walked. If the repository has a commit-graph
+// that contains a commit, its parents are read from the graph, as git does;
+// otherwise they are read from the commit itself.
+func (r *Repository) allAncestors(commit Commit) ([]Commit, error) {
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	parents := []Commit{}
+	seen := map[SHA]bool{commit.Name: true}
+	for {
+		name, ok, err := r.firstParent(commit)
 		if err != nil {
-			return nil, err
+			return parents, err
 		}
-	case Commit:
-		commit = obj
-	default:
-		return nil, fmt.Errorf("not a commit")
-	}
+		if !ok {
+			return parents, nil
+		}
+		if seen[name] {
+			return parents, fmt.Errorf("cycle in history at %s", name)
+		}
+		seen[name] = true
 
-	parents := []Commit{}
-	if len(commit.Parents) > 0 {
-		// By default, git-log uses the first parent in merges
-		obj, err := r.Object(commit.Parents[0])
+		obj, err := r.Object(name)
 		if err != nil {
-			return nil, err
+			return parents, err
 		}
 		parent, ok := obj.(Commit)
 		if !ok {
-			fmt.Println(reflect.TypeOf(obj))
-			return nil, fmt.Errorf("receved non-commit object parent: %s (%s)", commit.Parents[0], obj.Type())
+			return parents, fmt.Errorf("receved non-commit object parent: %s (%s)", name, obj.Type())
 		}
-
 		parents = append(parents, parent)
-		ancestors, err := r.allAncestors(SHA(commit.Parents[0]))
+		commit = parent
+	}
+}
+
+// firstParent returns the first parent of the commit, using the commit-graph
+// if it contains the commit. By default, git-log uses the first parent in merges.
+func (r *Repository) firstParent(commit Commit) (SHA, bool, error) {
+	parents := commit.Parents
+	if r.graph != nil {
+		if pos, ok := r.graph.find(commit.Name); ok {
+			node, err := r.graph.node(pos)
+			if err != nil {
+				return SHA{}, false, err
+			}
+			parents = node.Parents
+		}
+	}
+	if len(parents) == 0 {
+		return SHA{}, false, nil
+	}
+	return parents[0], true, nil
+}
+
+// History returns the commit and its first-parent ancestors, nearest first,
+// like Log. The commits are read from the commit-graph if it contains them,
+// which is much faster than reading the commits themselves.
+func (r *Repository) History(name SHA) ([]CommitNode, error) {
+	var history []CommitNode
+	seen := map[SHA]bool{}
+	for {
+		if seen[name] {
+			return nil, fmt.Errorf("cycle in history at %s", name)
+		}
+		seen[name] = true
+
+		node, err := r.CommitNode(name)
 		if err != nil {
-			return parents, err
+			return nil, err
+		}
+		history = append(history, node)
+
+		// By default, git-log uses the first parent in merges
+		if len(node.Parents) == 0 {
+			return history, nil
 		}
-		parents = append(parents, ancestors...)
+		name = node.Parents[0]
 	}
-	return parents, nil
 }
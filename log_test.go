@@ -1,13 +1,18 @@
 package gitgo
 
 import (
+	"encoding/binary"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
 func Test_Log(t *testing.T) {
-	const input SHA = "1d833eb5b6c5369c0cb7a4a3e20ded237490145f"
-	expected := []SHA{"1d833eb5b6c5369c0cb7a4a3e20ded237490145f", "a7f92c920ce85f07a33f948aa4fa2548b270024f", "97eed02ebe122df8fdd853c1215d8775f3d9f1a1"}
+	input := mustSHA("1d833eb5b6c5369c0cb7a4a3e20ded237490145f")
+	expected := []SHA{mustSHA("1d833eb5b6c5369c0cb7a4a3e20ded237490145f"), mustSHA("a7f92c920ce85f07a33f948aa4fa2548b270024f"), mustSHA("97eed02ebe122df8fdd853c1215d8775f3d9f1a1")}
 	parents, err := Log(input, RepoDir)
 	if err != nil {
 		t.Error(err)
@@ -32,9 +37,50 @@ func Test_Log(t *testing.T) {
 // If it fails, we want a warning, but still have a zero exit status
 // due to the system-specific nature of the test
 func Test_SlowLog(t *testing.T) {
-	const input SHA = "a3dda0b50b190caf79ea5074ed6490f30ea47cef"
+	input := mustSHA("a3dda0b50b190caf79ea5074ed6490f30ea47cef")
 	_, err := Log(input, nil)
 	if err != nil {
-		t.Skip("Failed to read %s: %s", input, err)
+		t.Skipf("Failed to read %s: %s", input, err)
+	}
+}
+
+func Test_LogCommitGraph(t *testing.T) {
+	// In the commit-graph, the first parent of the octopus merge e025f60
+	// is changed from 10b6a54 to 091ca37, so Log follows it only if it uses the graph
+	dir, repo := copyRepo(t, filepath.Join("test_data", "commit-graph", "dot_git"))
+	defer os.RemoveAll(dir)
+	tip := mustSHA("c6fd79491473c0bd854fb6235bf0e6d34d29d8cc")
+	names := func() []SHA {
+		commits, err := Log(tip, &repo.Basedir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result []SHA
+		for _, commit := range commits {
+			result = append(result, commit.Name)
+		}
+		return result
+	}
+	expected := []SHA{tip, mustSHA("4bdde50f0f338c043342fa6d6d30235e13b0c329"), mustSHA("e025f60170df5c2ed4caa057696a80c122411144"), mustSHA("10b6a54970fa6257957aae6f359c636068e3ce85"), mustSHA("f80f42f6bff4ad348fdf7873d3d86869a573649c")}
+	if result := names(); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v and received %v", expected, result)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join("test_data", "commit-graph", "commit-graph"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, _, err := parseCommitGraph(data, SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// e025f60 is the sixth commit in the graph, and 091ca37 is the first
+	binary.BigEndian.PutUint32(layer.data[5*(SHA1.Size()+16)+SHA1.Size():], 0)
+	if err := ioutil.WriteFile(filepath.Join(dir, ".git", "objects", "info", "commit-graph"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected[3] = mustSHA("091ca377ace8a1e3a00ff72e3b19cbd5a679717f")
+	if result := names(); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v and received %v", expected, result)
 	}
 }
@@ -0,0 +1,221 @@
+package gitgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// midxMagic is the first four bytes of a multi-pack-index file
+var midxMagic = []byte("MIDX")
+
+// multiPackIndex is the contents of a multi-pack-index file, which is written by
+// `git multi-pack-index write`. It lists the objects in several packfiles
+// in a single sorted table, so that an object can be found with one search
+// instead of one search per packfile.
+//
+// The file is laid out as:
+//
+//	header (12 bytes): magic number, version, hash version,
+//	  number of chunks, number of base files (always 0), and number of packfiles
+//	table of contents: the ID and offset of each chunk, ending with a zero ID
+//	chunks, including:
+//	  PNAM: the names of the pack indexes, separated by NUL bytes
+//	  OIDF: fanout table (256 4-byte entries)
+//	  OIDL: object names (count entries of 20 or 32 bytes)
+//	  OOFF: the packfile and offset of each object (count 8-byte entries)
+//	  LOFF: large offsets (8-byte entries, for offsets that do not fit in 31 bits)
+//	checksum of the file
+type multiPackIndex struct {
+	format ObjectFormat
+	count  int
+
+	// packs holds the packfile for each entry in PNAM, in order.
+	// Packfiles that are not listed are not covered by the index,
+	// and must be searched separately.
+	packs   []*packfile
+	covered map[*packfile]bool
+
+	fanout       []byte
+	names        []byte
+	offsets      []byte
+	largeOffsets []byte
+}
+
+// midx chunk IDs
+const (
+	midxPackNames    = "PNAM"
+	midxFanout       = "OIDF"
+	midxNames        = "OIDL"
+	midxOffsets      = "OOFF"
+	midxLargeOffsets = "LOFF"
+)
+
+// readMultiPackIndex reads objects/pack/multi-pack-index, if the repository has one,
+// and matches the pack indexes that it lists to the repository's packfiles
+func (r *Repository) readMultiPackIndex() (*multiPackIndex, error) {
+	data, err := ioutil.ReadFile(filepath.Join(r.Basedir.Name(), "objects", "pack", "multi-pack-index"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	midx, packNames, err := parseMultiPackIndex(data, r.format)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]*packfile{}
+	for _, pack := range r.packfiles {
+		byName[pack.name] = pack
+	}
+	for _, name := range packNames {
+		pack, ok := byName[strings.TrimSuffix(name, ".idx")]
+		if !ok {
+			return nil, fmt.Errorf("multi-pack-index refers to a missing packfile: %s", name)
+		}
+		midx.packs = append(midx.packs, pack)
+		midx.covered[pack] = true
+	}
+	return midx, nil
+}
+
+// parseMultiPackIndex parses the contents of a multi-pack-index file,
+// checking that each of the required chunks is present, and returns it
+// along with the names of the pack indexes that it covers
+func parseMultiPackIndex(data []byte, format ObjectFormat) (*multiPackIndex, []string, error) {
+	hashSize := format.Size()
+	if len(data) < 12+hashSize {
+		return nil, nil, fmt.Errorf("multi-pack-index is too short: %d bytes", len(data))
+	}
+	if !bytes.Equal(data[:4], midxMagic) {
+		return nil, nil, fmt.Errorf("invalid multi-pack-index signature: %q", data[:4])
+	}
+	if version := data[4]; version != 1 && version != 2 {
+		return nil, nil, fmt.Errorf("cannot parse multi-pack-index with version %d", version)
+	}
+	hashVersion := map[ObjectFormat]byte{SHA1: 1, SHA256: 2}[format]
+	if data[5] != hashVersion {
+		return nil, nil, fmt.Errorf("multi-pack-index uses hash version %d, but the repository uses %s", data[5], format)
+	}
+	numChunks := int(data[6])
+	if data[7] != 0 {
+		return nil, nil, fmt.Errorf("cannot parse multi-pack-index with %d base files", data[7])
+	}
+	numPacks := int(binary.BigEndian.Uint32(data[8:12]))
+
+	chunks, err := readChunks(data, "multi-pack-index", 12, numChunks, hashSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range []string{midxPackNames, midxFanout, midxNames, midxOffsets} {
+		if _, ok := chunks[id]; !ok {
+			return nil, nil, fmt.Errorf("invalid multi-pack-index: missing chunk %q", id)
+		}
+	}
+
+	// The names are NUL-terminated, and the chunk is padded with NUL bytes
+	var packNames []string
+	for _, name := range strings.Split(string(chunks[midxPackNames]), "\x00") {
+		if name != "" {
+			packNames = append(packNames, name)
+		}
+	}
+	if len(packNames) != numPacks {
+		return nil, nil, fmt.Errorf("invalid multi-pack-index: expected %d packfiles and found %d", numPacks, len(packNames))
+	}
+
+	midx := &multiPackIndex{format: format, covered: map[*packfile]bool{}}
+	midx.fanout = chunks[midxFanout]
+	midx.count, err = parseFanout(midx.fanout, "multi-pack-index")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	midx.names = chunks[midxNames]
+	midx.offsets = chunks[midxOffsets]
+	midx.largeOffsets = chunks[midxLargeOffsets]
+	if len(midx.names) != midx.count*hashSize || len(midx.offsets) != midx.count*8 {
+		return nil, nil, fmt.Errorf("invalid multi-pack-index: tables are the wrong size for %d objects", midx.count)
+	}
+	if len(midx.largeOffsets)%8 != 0 {
+		return nil, nil, fmt.Errorf("invalid multi-pack-index: large offset table is %d bytes long", len(midx.largeOffsets))
+	}
+	return midx, packNames, nil
+}
+
+// covers reports whether the objects in the packfile are listed in the index.
+// A nil index covers no packfiles.
+func (m *multiPackIndex) covers(pack *packfile) bool {
+	return m != nil && m.covered[pack]
+}
+
+// nameBytes returns the raw name of the i-th object in the index
+func (m *multiPackIndex) nameBytes(i int) []byte {
+	size := m.format.Size()
+	return m.names[i*size : (i+1)*size]
+}
+
+// name returns the name of the i-th object in the index
+func (m *multiPackIndex) name(i int) SHA {
+	return newSHA(m.nameBytes(i))
+}
+
+// location returns the packfile that contains the i-th object,
+// and the offset of the object within it
+func (m *multiPackIndex) location(i int) (*packfile, int64, error) {
+	entry := m.offsets[i*8:]
+	packID := binary.BigEndian.Uint32(entry)
+	if int(packID) >= len(m.packs) {
+		return nil, 0, fmt.Errorf("invalid multi-pack-index: object %s is in packfile %d of %d", m.name(i), packID, len(m.packs))
+	}
+	pack := m.packs[packID]
+
+	// If the MSB is set and there is a table of large offsets,
+	// the other 31 bits are an index into that table
+	offset := binary.BigEndian.Uint32(entry[4:])
+	if offset&0x80000000 == 0 || m.largeOffsets == nil {
+		return pack, int64(offset), nil
+	}
+	large := int(offset & 0x7fffffff)
+	if large >= len(m.largeOffsets)/8 {
+		return nil, 0, fmt.Errorf("invalid multi-pack-index: large offset %d for object %s is out of range", large, m.name(i))
+	}
+	result := binary.BigEndian.Uint64(m.largeOffsets[large*8:])
+	if result > math.MaxInt64 {
+		return nil, 0, fmt.Errorf("invalid multi-pack-index: offset %d for object %s is out of range", result, m.name(i))
+	}
+	return pack, int64(result), nil
+}
+
+// bucket returns the range of positions of the objects whose names begin with the given byte
+func (m *multiPackIndex) bucket(b byte) (start, end int) {
+	if b > 0 {
+		start = int(binary.BigEndian.Uint32(m.fanout[(int(b)-1)*4:]))
+	}
+	end = int(binary.BigEndian.Uint32(m.fanout[int(b)*4:]))
+	return start, end
+}
+
+// find returns the position of the object with the given name
+func (m *multiPackIndex) find(name SHA) (int, bool) {
+	target := name.Bytes()
+	if len(target) != m.format.Size() {
+		return 0, false
+	}
+	start, end := m.bucket(target[0])
+	i := start + sort.Search(end-start, func(i int) bool {
+		return bytes.Compare(m.nameBytes(start+i), target) >= 0
+	})
+	if i < end && bytes.Equal(m.nameBytes(i), target) {
+		return i, true
+	}
+	return 0, false
+}
@@ -0,0 +1,251 @@
+package gitgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_MultiPackIndex(t *testing.T) {
+	// The multi-pack-index covers the full pack and one of the thin packs,
+	// but not the other thin pack
+	repo := Repository{Basedir: *RefDeltaRepoDir}
+	if err := repo.load(); err != nil {
+		t.Fatal(err)
+	}
+	if repo.midx == nil {
+		t.Fatal("expected a multi-pack-index")
+	}
+	if len(repo.midx.packs) != 2 {
+		t.Fatalf("expected 2 packfiles and received %d", len(repo.midx.packs))
+	}
+
+	var count int
+	for _, pack := range repo.packfiles {
+		if !repo.midx.covers(pack) {
+			if pack.name != "pack-bf789cfabf1edfb813df55daeb13b876d37f4c34" {
+				t.Errorf("expected %s to be covered", pack.name)
+			}
+			continue
+		}
+		count += pack.idx.count
+		for i := 0; i < pack.idx.count; i++ {
+			name := pack.idx.name(i)
+			expected, err := pack.idx.offset(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			j, ok := repo.midx.find(name)
+			if !ok {
+				t.Errorf("%s not found in multi-pack-index", name)
+				continue
+			}
+			found, offset, err := repo.midx.location(j)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != pack || offset != expected {
+				t.Errorf("expected %s at %s:%d and received %s:%d", name, pack.name, expected, found.name, offset)
+			}
+		}
+	}
+	if repo.midx.count != count {
+		t.Errorf("expected %d objects and received %d", count, repo.midx.count)
+	}
+
+	// 835657 is only in the pack that is not covered
+	name := mustSHA("835657c7f5c7513fa20a201ff1ca0b01f75312fd")
+	pack, _, ok, err := repo.findPacked(name)
+	if err != nil || !ok || repo.midx.covers(pack) {
+		t.Errorf("expected to find %s outside the multi-pack-index (%v)", name, err)
+	}
+	for _, prefix := range []string{"835657", "f941086", "4223f1a8"} {
+		if _, err := repo.ResolvePrefix(prefix); err != nil {
+			t.Errorf("could not resolve %s: %s", prefix, err)
+		}
+	}
+}
+
+func Test_MultiPackIndexObject(t *testing.T) {
+	// Every object is read through the multi-pack-index, except 835657,
+	// and each is hashed to check that it was resolved correctly.
+	// 4223f1 and f941086 are REF_DELTAs, and the base of f941086 is in another packfile.
+	repo := Repository{Basedir: *RefDeltaRepoDir, Verify: true}
+	expected := map[string]string{
+		"dc5a4f53d73b3714896d340f187d48a5569c8eb0": "commit",
+		"c79b5f179f76f184fb455a551a95f073e702b459": "tree",
+		"873285b0320556fcd55b029aacbb7b2fe724a6fd": "blob",
+		"4223f1a8c57a281d5e41c329a4f983a7bb3b57a0": "blob",
+		"f941086d4c84a3b159f7361e23513057736e3cd9": "blob",
+		"835657c7f5c7513fa20a201ff1ca0b01f75312fd": "blob",
+	}
+	for name, objType := range expected {
+		obj, err := repo.Object(mustSHA(name))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if obj.Type() != objType {
+			t.Errorf("%s: expected %s and received %s", name, objType, obj.Type())
+		}
+	}
+	if repo.midx == nil {
+		t.Errorf("expected a multi-pack-index")
+	}
+}
+
+func Test_MultiPackIndexDeltaBase(t *testing.T) {
+	// The base of f941086, 873285, is listed in the multi-pack-index as if it were
+	// in the same packfile as f941086, so the other packfiles must be searched for it
+	repo := Repository{Basedir: *RefDeltaRepoDir}
+	if err := repo.load(); err != nil {
+		t.Fatal(err)
+	}
+	i, ok := repo.midx.find(mustSHA("873285b0320556fcd55b029aacbb7b2fe724a6fd"))
+	if !ok {
+		t.Fatal("expected the base to be in the multi-pack-index")
+	}
+	for id, pack := range repo.midx.packs {
+		if strings.HasSuffix(pack.path(".pack"), "pack-afe6da812e43c6acd0587a67da7e2f2360cd9403.pack") {
+			repo.midx.offsets = append([]byte(nil), repo.midx.offsets...)
+			binary.BigEndian.PutUint32(repo.midx.offsets[i*8:], uint32(id))
+		}
+	}
+
+	obj, err := repo.Object(mustSHA("f941086d4c84a3b159f7361e23513057736e3cd9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Type() != "blob" {
+		t.Errorf("expected a blob and received %s", obj.Type())
+	}
+}
+
+func Test_MultiPackIndexDamaged(t *testing.T) {
+	// A multi-pack-index that cannot be read is ignored
+	packDir := filepath.Join("objects", "pack")
+	damage := map[string]func(dir string) error{
+		"missing packfile": func(dir string) error {
+			return os.Remove(filepath.Join(dir, packDir, "pack-afe6da812e43c6acd0587a67da7e2f2360cd9403.pack"))
+		},
+		"invalid signature": func(dir string) error {
+			filename := filepath.Join(dir, packDir, "multi-pack-index")
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			data[0] ^= 0xff
+			return ioutil.WriteFile(filename, data, 0644)
+		},
+	}
+	name := mustSHA("4223f1a8c57a281d5e41c329a4f983a7bb3b57a0")
+	for description, f := range damage {
+		dir, repo := copyRepo(t, filepath.Join("test_data", "ref-delta", "dot_git"))
+		defer os.RemoveAll(dir)
+		if err := f(repo.Basedir.Name()); err != nil {
+			t.Fatal(err)
+		}
+
+		obj, err := repo.Object(name)
+		if err != nil {
+			t.Errorf("%s: %s", description, err)
+			continue
+		}
+		if repo.midx != nil {
+			t.Errorf("%s: expected the multi-pack-index to be ignored", description)
+		}
+		if obj.Type() != "blob" {
+			t.Errorf("%s: expected a blob and received %s", description, obj.Type())
+		}
+	}
+}
+
+// buildChunkFile returns a file in git's chunk format, with the given header
+// followed by the table of contents, the chunks, and an empty SHA-1 checksum
+func buildChunkFile(header []byte, chunks [][2]string) []byte {
+	var buf bytes.Buffer
+	var fileChunks []fileChunk
+	for _, chunk := range chunks {
+		fileChunks = append(fileChunks, fileChunk{chunk[0], []byte(chunk[1])})
+	}
+	writeChunks(&buf, header, fileChunks)
+	buf.Write(make([]byte, SHA1.Size()))
+	return buf.Bytes()
+}
+
+// buildMultiPackIndex returns a multi-pack-index with the given chunks
+func buildMultiPackIndex(numPacks int, chunks [][2]string) []byte {
+	header := []byte{'M', 'I', 'D', 'X', 1, 1, byte(len(chunks)), 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[8:], uint32(numPacks))
+	return buildChunkFile(header, chunks)
+}
+
+func Test_parseMultiPackIndex(t *testing.T) {
+	// Two objects, the second of which is at a large offset in the second packfile
+	names := []SHA{mustSHA("0100000000000000000000000000000000000000"), mustSHA("ff00000000000000000000000000000000000000")}
+	var fanout, oidl, ooff, loff bytes.Buffer
+	for i := 0; i < 256; i++ {
+		n := uint32(0)
+		if i >= 1 {
+			n++
+		}
+		if i == 255 {
+			n++
+		}
+		binary.Write(&fanout, binary.BigEndian, n)
+	}
+	oidl.Write(names[0].Bytes())
+	oidl.Write(names[1].Bytes())
+	binary.Write(&ooff, binary.BigEndian, []uint32{0, 12, 1, 0x80000000})
+	binary.Write(&loff, binary.BigEndian, uint64(1<<32))
+	chunks := [][2]string{
+		{"PNAM", "pack-a.idx\x00pack-b.idx\x00\x00\x00"},
+		{"OIDF", fanout.String()},
+		{"OIDL", oidl.String()},
+		{"OOFF", ooff.String()},
+		{"LOFF", loff.String()},
+	}
+
+	midx, packNames, err := parseMultiPackIndex(buildMultiPackIndex(2, chunks), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packNames) != 2 || packNames[1] != "pack-b.idx" {
+		t.Errorf("unexpected pack names: %v", packNames)
+	}
+	packs := []*packfile{{name: "pack-a"}, {name: "pack-b"}}
+	midx.packs = packs
+	for i, expected := range []int64{12, 1 << 32} {
+		j, ok := midx.find(names[i])
+		if !ok || j != i {
+			t.Fatalf("expected to find %s at %d", names[i], i)
+		}
+		pack, offset, err := midx.location(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pack != packs[i] || offset != expected {
+			t.Errorf("expected %s:%d and received %s:%d", packs[i].name, expected, pack.name, offset)
+		}
+	}
+
+	invalid := map[string][]byte{
+		"missing chunk":      buildMultiPackIndex(2, chunks[:3]),
+		"wrong pack count":   buildMultiPackIndex(3, chunks),
+		"wrong object count": buildMultiPackIndex(2, append([][2]string{chunks[0], chunks[1], {"OIDL", oidl.String()[:20]}}, chunks[3:]...)),
+		"short fanout":       buildMultiPackIndex(2, append([][2]string{chunks[0], {"OIDF", fanout.String()[4:]}}, chunks[2:]...)),
+		"truncated":          buildMultiPackIndex(2, chunks)[:40],
+	}
+	for name, data := range invalid {
+		if _, _, err := parseMultiPackIndex(data, SHA1); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, _, err := parseMultiPackIndex(buildMultiPackIndex(2, chunks), SHA256); err == nil {
+		t.Errorf("expected an error for the wrong hash version")
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"compress/zlib"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -19,7 +18,7 @@ const (
 	RFC2822 = "Mon Jan 2 15:04:05 2006 -0700"
 )
 
-// GitObject represents a commit, tree, or blob.
+// GitObject represents a commit, tree, blob, or tag.
 // Under the hood, these may be objects stored directly
 // or through packfiles
 type GitObject interface {
@@ -39,8 +38,7 @@ type gitObject struct {
 	Size      string
 
 	// Tree
-	Blobs []objectMeta
-	Trees []objectMeta
+	Entries []TreeEntry
 
 	// Blob
 	Contents []byte
@@ -59,17 +57,40 @@ func (b Blob) Type() string {
 }
 
 type Commit struct {
-	_type         string
-	Name          SHA
-	Tree          string
-	Parents       []SHA
-	Author        string
-	AuthorDate    time.Time
-	Committer     string
-	CommitterDate time.Time
-	Message       []byte
-	size          string
-	rawData       []byte
+	_type     string
+	Name      SHA
+	Tree      SHA
+	Parents   []SHA
+	Author    Signature
+	Committer Signature
+	Message   []byte
+
+	// Encoding is the character encoding of the message, if it is not UTF-8
+	Encoding string
+
+	// GPGSig and GPGSigSHA256 hold the signatures over the commit, if it is signed.
+	// MergeTags holds the tag objects recorded when merging signed tags.
+	GPGSig       []byte
+	GPGSigSHA256 []byte
+	MergeTags    [][]byte
+
+	// ExtraHeaders holds any other headers, in the order they appear
+	ExtraHeaders []ExtraHeader
+
+	// headerOrder lists the keys of the headers in the order they were parsed,
+	// if it is not the order that Encode writes them in
+	headerOrder []string
+
+	size    string
+	rawData []byte
+}
+
+// An ExtraHeader is a commit header that gitgo does not interpret.
+// If the header spans multiple lines, the lines are separated by newlines
+// in Value, without the leading space that marks each continuation line.
+type ExtraHeader struct {
+	Key   string
+	Value []byte
 }
 
 func (c Commit) Type() string {
@@ -78,22 +99,113 @@ func (c Commit) Type() string {
 
 type Tree struct {
 	_type string
-	Blobs []objectMeta
-	Trees []objectMeta
+
+	// Entries lists every entry in the tree, in the order
+	// in which they are stored
+	Entries []TreeEntry
+
+	// Blobs and Trees list the entries that refer to
+	// blobs and subtrees, respectively.
+	// Submodules (gitlinks) appear only in Entries.
+	Blobs []TreeEntry
+	Trees []TreeEntry
 	size  string
+
+	// rawModes holds the text of each mode that was parsed in a form
+	// other than the one Encode writes, such as "040000", by entry name
+	rawModes map[string]string
 }
 
 func (t Tree) Type() string {
 	return t._type
 }
 
-// objectMeta contains the metadata
-// (hash, permissions, and filename)
-// corresponding either to a blob (leaf) or another tree
-type objectMeta struct {
-	Hash     SHA
-	Perms    string
-	filename string
+// A Tag is an annotated tag. It points to another object
+// (usually a commit) and carries its own tagger and message.
+type Tag struct {
+	_type      string
+	Name       SHA
+	Object     SHA
+	ObjectType string
+	Tag        string
+	Tagger     Signature
+	Message    []byte
+
+	// GPGSig is the signature appended to the tag message, if the tag is signed.
+	// It is not included in Message.
+	GPGSig []byte
+	size   string
+}
+
+func (t Tag) Type() string {
+	return t._type
+}
+
+// A TreeEntry contains the metadata
+// (hash, mode, and filename)
+// corresponding to a blob (leaf), another tree, or a submodule
+type TreeEntry struct {
+	Name string
+	Mode FileMode
+	Hash SHA
+}
+
+// FileMode is the mode of an entry in a tree.
+type FileMode uint32
+
+const (
+	ModeTree       FileMode = 0040000
+	ModeRegular    FileMode = 0100644
+	ModeExecutable FileMode = 0100755
+	ModeSymlink    FileMode = 0120000
+
+	// ModeGitlink is used for submodules. The entry's Hash
+	// refers to a commit in another repository.
+	ModeGitlink FileMode = 0160000
+)
+
+// String returns the mode as it is displayed by git (eg, "100644" or "040000")
+func (m FileMode) String() string {
+	return fmt.Sprintf("%06o", uint32(m))
+}
+
+// treeString returns the mode as git writes it within a tree object,
+// which is not zero-padded (eg, "100644" or "40000")
+func (m FileMode) treeString() string {
+	return strconv.FormatUint(uint64(m), 8)
+}
+
+// IsTree reports whether the entry is a subtree
+func (m FileMode) IsTree() bool {
+	return m&0170000 == ModeTree
+}
+
+// IsBlob reports whether the entry is a blob.
+// This includes regular files, executables, and symlinks.
+func (m FileMode) IsBlob() bool {
+	return m&0170000 == 0100000 || m&0170000 == ModeSymlink
+}
+
+// IsSubmodule reports whether the entry is a gitlink
+func (m FileMode) IsSubmodule() bool {
+	return m&0170000 == ModeGitlink
+}
+
+// Object reads the object that the entry refers to.
+// Submodules are stored in separate repositories, so they cannot be read.
+func (e TreeEntry) Object(r *Repository) (GitObject, error) {
+	if e.Mode.IsSubmodule() {
+		return nil, fmt.Errorf("%s is a submodule: commit %s is in another repository", e.Name, e.Hash)
+	}
+	return r.Object(e.Hash)
+}
+
+func parseFileMode(mode []byte) (FileMode, error) {
+	m, err := strconv.ParseUint(string(mode), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid file mode %q", mode)
+	}
+	return FileMode(m), nil
 }
 
 func NewObject(input SHA, basedir os.File) (obj GitObject, err error) {
@@ -101,8 +213,9 @@ func NewObject(input SHA, basedir os.File) (obj GitObject, err error) {
 	return repo.Object(input)
 }
 
-func newObject(input SHA, basedir *os.File, packfiles []*packfile) (obj GitObject, err error) {
-
+// newObject reads the object with the given name from the repository,
+// looking for a loose object before searching the packfiles
+func newObject(r *Repository, input SHA, basedir *os.File) (obj GitObject, err error) {
 	if filepath.Base(basedir.Name()) != ".git" {
 		defer basedir.Close()
 		basedir, err = os.Open(filepath.Join(basedir.Name(), ".git"))
@@ -132,50 +245,38 @@ func newObject(input SHA, basedir *os.File, packfiles []*packfile) (obj GitObjec
 		candidateName = filepath.Join(candidate.Name(), "..", "..", ".git")
 	}
 
-	if len(input) < 4 {
-		return nil, fmt.Errorf("input SHA must be at least 4 characters")
-	}
-
-	filename := filepath.Join(basedir.Name(), "objects", string(input[:2]), string(input[2:]))
+	filename := loosePath(basedir.Name(), input)
 	_, err = os.Stat(filename)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return nil, err
 		}
 
-		// check the directory for a file with the SHA as a prefix
-		_, err = os.Stat(filepath.Join(basedir.Name(), "objects", string(input[:2])))
+		// try the packfiles, through the multi-pack-index if there is one
+		pack, offset, ok, err := r.findPacked(input)
 		if err != nil {
-			if !os.IsNotExist(err) {
-				return nil, err
-			}
-		} else {
-			dirname := filepath.Join(basedir.Name(), "objects", string(input[:2]))
-			files, err := ioutil.ReadDir(dirname)
-			if err != nil {
-				return nil, err
-			}
-			for _, file := range files {
-				if strings.HasPrefix(file.Name(), string(input[2:])) {
-					return objectFromFile(filepath.Join(dirname, file.Name()), input, *basedir)
-				}
-			}
+			return nil, err
 		}
-
-		// try the packfile
-		for _, pack := range packfiles {
-			if p, ok := pack.objects[input]; ok {
-				return p.normalize(*basedir)
-			}
-			for _, object := range pack.objects {
-				if strings.HasPrefix(string(object.Name), string(input)) {
-					return object.normalize(*basedir)
-				}
+		if !ok {
+			return nil, fmt.Errorf("object not in any packfile: %s", input)
+		}
+		p, err := pack.object(input, offset)
+		if err != nil {
+			return nil, err
+		}
+		if r.Verify {
+			if err := pack.checkObject(p); err != nil {
+				return nil, err
 			}
 		}
-		return nil, fmt.Errorf("object not in any packfile: %s", input)
+		return p.normalize(*basedir)
 	}
+	return objectFromFile(filename, input, r.Verify)
+}
 
+// objectFromFile reads a loose object. If verify is set, the object
+// is hashed (including its header) and compared to its name before it is parsed.
+func objectFromFile(filename string, name SHA, verify bool) (GitObject, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
@@ -183,37 +284,59 @@ func newObject(input SHA, basedir *os.File, packfiles []*packfile) (obj GitObjec
 	defer f.Close()
 	r, err := zlib.NewReader(f)
 	if err != nil {
+		if verify {
+			return nil, &CorruptObjectError{Name: name, Path: filename, Offset: -1, Err: err}
+		}
 		return nil, err
 	}
-	return parseObj(r, input, *basedir)
+	if !verify {
+		return parseObj(r, name)
+	}
 
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, &CorruptObjectError{Name: name, Path: filename, Offset: -1, Err: err}
+	}
+	h := name.Format().New()
+	h.Write(data)
+	if actual := newSHA(h.Sum(nil)); actual != name {
+		return nil, &CorruptObjectError{Name: name, Actual: actual, Path: filename, Offset: -1}
+	}
+	return parseObj(bytes.NewReader(data), name)
 }
 
-func objectFromFile(filename string, name SHA, basedir os.File) (GitObject, error) {
+// readLooseObject reads the type and contents of a loose object, without parsing it
+func readLooseObject(filename string) (objType string, data []byte, err error) {
 	f, err := os.Open(filename)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	defer f.Close()
 	r, err := zlib.NewReader(f)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
-	return parseObj(r, name, basedir)
-}
-
-func normalizePerms(perms string) string {
-	// TODO don't store permissions as a string
-	for len(perms) < 6 {
-		perms = "0" + perms
+	objType, size, err := readObjectHeader(r)
+	if err != nil {
+		return "", nil, err
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid object size %q", size)
+	}
+	data, err = ioutil.ReadAll(r)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(data) != n {
+		return "", nil, fmt.Errorf("expected %d bytes and found %d", n, len(data))
 	}
-	return perms
+	return objType, data, nil
 }
 
-func parseObj(r io.Reader, name SHA, basedir os.File) (result GitObject, err error) {
-
-	var resultType string
-	var resultSize string
+// readObjectHeader reads the "<type> <size>\x00" header of a loose object.
+// It never reads past the header, so r can be used to read the object contents afterwards.
+func readObjectHeader(r io.Reader) (resultType string, resultSize string, err error) {
 	scnr := scanner{r, nil, nil}
 	for scnr.scan() {
 		txt := string(scnr.data)
@@ -231,17 +354,24 @@ func parseObj(r io.Reader, name SHA, basedir os.File) (result GitObject, err err
 		resultSize += txt
 	}
 
-	if scnr.Err() != nil {
-		return nil, scnr.Err()
+	return resultType, resultSize, scnr.Err()
+}
+
+func parseObj(r io.Reader, name SHA) (result GitObject, err error) {
+	resultType, resultSize, err := readObjectHeader(r)
+	if err != nil {
+		return nil, err
 	}
 
 	switch resultType {
 	case "commit":
 		return parseCommit(r, resultSize, name)
 	case "tree":
-		return parseTree(r, resultSize, basedir)
+		return parseTree(r, resultSize, name.Format())
 	case "blob":
 		return parseBlob(r, resultSize)
+	case "tag":
+		return parseTag(r, resultSize, name)
 	default:
 		err = fmt.Errorf("Received unknown object type %s", resultType)
 	}
@@ -252,133 +382,161 @@ func parseObj(r io.Reader, name SHA, basedir os.File) (result GitObject, err err
 func parseCommit(r io.Reader, resultSize string, name SHA) (Commit, error) {
 	var commit = Commit{_type: "commit", size: resultSize}
 
-	scnr := bufio.NewScanner(r)
-	scnr.Split(ScanLinesNoTrim)
-
-	var commitMessageLines [][]byte
-	for scnr.Scan() {
-		line := scnr.Bytes()
-		trimmedLine := bytes.TrimRight(line, "\r\n")
-		if commitMessageLines == nil && len(bytes.Fields(trimmedLine)) == 0 {
-			// Everything after the first empty line is the commit message
-			commitMessageLines = [][]byte{}
-			continue
-		}
-
-		if commitMessageLines != nil {
-			// We have already seen an empty line
-			commitMessageLines = append(commitMessageLines, line)
-			continue
-		}
+	headers, message, err := parseHeaders(r)
+	if err != nil {
+		return commit, err
+	}
 
-		parts := bytes.Fields(trimmedLine)
-		key := parts[0]
-		switch keyType(key) {
+	for _, header := range headers {
+		switch keyType(header.Key) {
 		case treeKey:
-			commit.Tree = string(parts[1])
+			commit.Tree, err = ParseSHA(string(header.Value))
+			if err != nil {
+				return commit, err
+			}
 		case parentKey:
-			commit.Parents = append(commit.Parents, SHA(string(parts[1])))
+			parent, err := ParseSHA(string(header.Value))
+			if err != nil {
+				return commit, err
+			}
+			commit.Parents = append(commit.Parents, parent)
 		case authorKey:
-			authorline := string(bytes.Join(parts[1:], []byte(" ")))
-			author, date, err := parseAuthorString(authorline)
+			author, err := parseSignature(string(header.Value))
 			if err != nil {
 				return commit, err
 			}
 			commit.Author = author
-			commit.AuthorDate = date
 		case committerKey:
-			committerline := string(bytes.Join(parts[1:], []byte(" ")))
-			committer, date, err := parseCommitterString(committerline)
+			committer, err := parseSignature(string(header.Value))
 			if err != nil {
 				return commit, err
 			}
 			commit.Committer = committer
-			commit.CommitterDate = date
+		case encodingKey:
+			commit.Encoding = string(header.Value)
+		case gpgsigKey:
+			commit.GPGSig = append(header.Value, '\n')
+		case gpgsigSHA256Key:
+			commit.GPGSigSHA256 = append(header.Value, '\n')
+		case mergetagKey:
+			commit.MergeTags = append(commit.MergeTags, append(header.Value, '\n'))
 		default:
-			err := fmt.Errorf("encountered unknown field in commit: %s", key)
-			return commit, err
+			commit.ExtraHeaders = append(commit.ExtraHeaders, header)
 		}
 	}
 	commit.Name = name
-	commit.Message = bytes.Join(commitMessageLines, []byte("\n"))
+	commit.Message = message
+
+	// Record the order of the headers only if it differs from the usual one
+	expected := commit.headers()
+	for i, header := range headers {
+		if i >= len(expected) || header.Key != expected[i].Key {
+			commit.headerOrder = make([]string, len(headers))
+			for j, header := range headers {
+				commit.headerOrder[j] = header.Key
+			}
+			break
+		}
+	}
 	return commit, nil
 }
 
-func parseTree(r io.Reader, resultSize string, basedir os.File) (Tree, error) {
-	var tree = Tree{_type: "tree", size: resultSize}
-
-	scanner := bufio.NewScanner(r)
-	scanner.Split(ScanNullLines)
-
-	var tmp objectMeta
-
-	var resultObjs []objectMeta
+// parseHeaders reads the headers of a commit or tag, followed by its message.
+// A header line that begins with a space continues the value of the previous header;
+// the lines of a multi-line value are separated by newlines in the result.
+func parseHeaders(r io.Reader) (headers []ExtraHeader, message []byte, err error) {
+	scnr := bufio.NewScanner(r)
+	scnr.Split(ScanLinesNoTrim)
 
-	for count := 0; ; count++ {
-		done := !scanner.Scan()
-		if done {
-			break
+	for scnr.Scan() {
+		line := scnr.Bytes()
+		if message != nil {
+			// We have already seen an empty line
+			message = append(message, line...)
+			continue
 		}
 
-		txt := scanner.Text()
-
-		if count == 0 {
-			// the first time through, scanner.Text() will be
-			// <perms> <filename>
-			// separated by a space
-			fields := strings.Fields(txt)
-			tmp.Perms = normalizePerms(fields[0])
-			tmp.filename = fields[1]
+		trimmedLine := bytes.TrimSuffix(line, []byte("\n"))
+		if len(trimmedLine) == 0 {
+			// Everything after the first empty line is the message
+			message = []byte{}
 			continue
 		}
 
-		// after the first time through, scanner.Text() will be
-		// <sha><perms2> <file2>
-		// where perms2 and file2 refer to the permissions and filename (respectively)
-		// of the NEXT object, and <sha> is the first 20 bytes exactly.
-		// If there is no next object (this is the last object)
-		// then scanner.Text() will yield exactly 20 bytes.
-
-		// decode the next 20 bytes to get the SHA
-		tmp.Hash = SHA(hex.EncodeToString([]byte(txt[:20])))
-		resultObjs = append(resultObjs, tmp)
-		if len(txt) <= 20 {
-			// We've read the last line
-			break
+		if trimmedLine[0] == ' ' {
+			if len(headers) == 0 {
+				return nil, nil, fmt.Errorf("continuation line without a header: %q", trimmedLine)
+			}
+			last := &headers[len(headers)-1]
+			last.Value = append(append(last.Value, '\n'), trimmedLine[1:]...)
+			continue
 		}
 
-		// Now, tmp points to the next object in the tree listing
-		tmp = objectMeta{}
-		remainder := txt[20:]
-		fields := strings.Fields(remainder)
-		tmp.Perms = normalizePerms(fields[0])
-		tmp.filename = fields[1]
+		// the scanner reuses its buffer, so the value must be copied
+		parts := bytes.SplitN(trimmedLine, []byte(" "), 2)
+		header := ExtraHeader{Key: string(parts[0]), Value: []byte{}}
+		if len(parts) == 2 {
+			header.Value = append(header.Value, parts[1]...)
+		}
+		headers = append(headers, header)
+	}
+	if err := scnr.Err(); err != nil {
+		return nil, nil, err
 	}
+	if message == nil {
+		message = []byte{}
+	}
+	return headers, message, nil
+}
 
-	if err := scanner.Err(); err != nil && err != io.EOF {
+// parseTree parses a tree object whose entries are named using the given format.
+// The entries are classified by their modes, so none of the objects that the tree refers to are read.
+func parseTree(r io.Reader, resultSize string, format ObjectFormat) (Tree, error) {
+	var tree = Tree{_type: "tree", size: resultSize}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
 		return tree, err
 	}
 
-	for _, part := range resultObjs {
-		obj, err := NewObject(part.Hash, basedir)
+	// Each entry is
+	// <mode> <filename>\x00<sha>
+	// where <sha> is exactly 20 (or, for SHA-256, 32) bytes, and may itself contain null bytes
+	hashSize := format.Size()
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		if space < 0 {
+			return tree, fmt.Errorf("malformed tree entry: missing mode")
+		}
+		modeText := data[:space]
+		mode, err := parseFileMode(modeText)
 		if err != nil {
 			return tree, err
 		}
+		data = data[space+1:]
 
-		if o, ok := obj.(*packObject); ok {
-			obj, err = o.normalize(basedir)
-			if err != nil {
-				return tree, err
+		null := bytes.IndexByte(data, '\x00')
+		if null < 0 || len(data) < null+1+hashSize {
+			return tree, fmt.Errorf("malformed tree entry: truncated after mode %s", mode)
+		}
+		name := string(data[:null])
+		hash := newSHA(data[null+1 : null+1+hashSize])
+		data = data[null+1+hashSize:]
+
+		entry := TreeEntry{Name: name, Mode: mode, Hash: hash}
+		tree.Entries = append(tree.Entries, entry)
+		if raw := string(modeText); raw != mode.treeString() {
+			if tree.rawModes == nil {
+				tree.rawModes = map[string]string{}
 			}
+			tree.rawModes[name] = raw
 		}
 
-		switch obj.Type() {
-		case "tree":
-			tree.Trees = append(tree.Trees, part)
-		case "blob":
-			tree.Blobs = append(tree.Blobs, part)
-		default:
-			return tree, fmt.Errorf("Unknown type found: %s", obj.Type())
+		switch {
+		case mode.IsTree():
+			tree.Trees = append(tree.Trees, entry)
+		case mode.IsBlob():
+			tree.Blobs = append(tree.Blobs, entry)
 		}
 	}
 	return tree, nil
@@ -391,72 +549,162 @@ func parseBlob(r io.Reader, resultSize string) (Blob, error) {
 	return blob, err
 }
 
-func findUniquePrefix(prefix SHA, files []os.FileInfo) (os.FileInfo, error) {
-	var result os.FileInfo
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		if strings.HasPrefix(file.Name(), string(prefix)) {
-			if result != nil {
-				return nil, fmt.Errorf("prefix is not unique: %s", prefix)
+func parseTag(r io.Reader, resultSize string, name SHA) (Tag, error) {
+	var tag = Tag{_type: "tag", size: resultSize}
+
+	headers, message, err := parseHeaders(r)
+	if err != nil {
+		return tag, err
+	}
+
+	for _, header := range headers {
+		switch keyType(header.Key) {
+		case objectKey:
+			tag.Object, err = ParseSHA(string(header.Value))
+			if err != nil {
+				return tag, err
+			}
+		case typeKey:
+			tag.ObjectType = string(header.Value)
+		case tagKey:
+			tag.Tag = string(header.Value)
+		case taggerKey:
+			tagger, err := parseSignature(string(header.Value))
+			if err != nil {
+				return tag, err
 			}
-			result = file
+			tag.Tagger = tagger
+		default:
+			err := fmt.Errorf("encountered unknown field in tag: %s", header.Key)
+			return tag, err
 		}
 	}
-	if result == nil {
-		return nil, os.ErrNotExist
+	if tag.Object.IsZero() || tag.ObjectType == "" {
+		return tag, fmt.Errorf("tag %s is missing its object or type", name)
 	}
-	return result, nil
+
+	tag.Name = name
+	tag.Message, tag.GPGSig = splitSignature(message)
+	return tag, nil
 }
 
-// The ommitter string is in the same format as
-// the author string, and oftentimes shares
-// the same value as the author string.
+// signatureHeaders are the lines that can begin a signature
+// appended to a tag message
+var signatureHeaders = [][]byte{
+	[]byte("-----BEGIN PGP SIGNATURE-----"),
+	[]byte("-----BEGIN PGP MESSAGE-----"),
+	[]byte("-----BEGIN SIGNED MESSAGE-----"),
+	[]byte("-----BEGIN SSH SIGNATURE-----"),
+}
 
-func parseCommitterString(str string) (committer string, date time.Time, err error) {
-	return parseAuthorString(str)
+// splitSignature separates a signed message from its signature.
+// Like git, it treats the last line that begins a signature
+// as the start of the signature.
+func splitSignature(message []byte) (msg []byte, sig []byte) {
+	match := len(message)
+	for i := 0; i < len(message); {
+		for _, header := range signatureHeaders {
+			if bytes.HasPrefix(message[i:], header) {
+				match = i
+				break
+			}
+		}
+		eol := bytes.IndexByte(message[i:], '\n')
+		if eol < 0 {
+			break
+		}
+		i += eol + 1
+	}
+	if match == len(message) {
+		return message, nil
+	}
+	return message[:match], message[match:]
 }
 
-// parseAuthorString parses the author string.
-func parseAuthorString(str string) (author string, date time.Time, err error) {
-	const layout = "Mon Jan _2 15:04:05 2006 -0700"
-	const layout2 = "Mon Jan _2 15:04:05 2006"
-	var authorW bytes.Buffer
-	var dateW bytes.Buffer
+// A Signature identifies the author, committer, or tagger of an object
+// and the time at which they acted.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
 
-	s := bufio.NewScanner(strings.NewReader(str))
-	s.Split(bufio.ScanBytes)
+	// Offset is the timezone offset exactly as it was recorded (eg, "-0400").
+	// time.Time cannot distinguish "-0000" from "+0000", so the original
+	// text is kept for re-serialization.
+	Offset string
 
-	// git will ignore '<' if it appears in an author's name
-	// so we can safely use it as a delimiter
-	for s.Scan() {
-		authorW.Write(s.Bytes())
-		if s.Text() == ">" {
-			break
+	// raw is the line the Signature was parsed from, if any
+	raw string
+}
+
+// String returns the Signature in the format used in git objects:
+// Name <email> <unix timestamp> <offset>
+func (s Signature) String() string {
+	if s.raw != "" {
+		// Reuse the original text as long as the Signature has not been changed,
+		// so that unusual (but valid) identities are preserved byte-for-byte
+		orig, err := parseSignature(s.raw)
+		if err == nil && orig.Name == s.Name && orig.Email == s.Email && orig.When.Equal(s.When) && orig.Offset == s.Offset {
+			return s.raw
 		}
 	}
-	for s.Scan() {
-		dateW.Write(s.Bytes())
+	offset := s.Offset
+	if offset == "" {
+		offset = s.When.Format("-0700")
 	}
-	if s.Err() != nil {
-		err = s.Err()
-		return
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When.Unix(), offset)
+}
+
+// parseSignature parses an author, committer, or tagger line
+// (without the leading key).
+func parseSignature(str string) (Signature, error) {
+	sig := Signature{raw: str}
+
+	// git will ignore '<' if it appears in an author's name
+	// so we can safely use it as a delimiter
+	emailStart := strings.IndexByte(str, '<')
+	emailEnd := strings.LastIndexByte(str, '>')
+	if emailStart < 0 || emailEnd < emailStart {
+		return sig, fmt.Errorf("malformed signature: %q", str)
 	}
+	sig.Name = strings.TrimRight(str[:emailStart], " ")
+	sig.Email = str[emailStart+1 : emailEnd]
 
-	timestamp, err := strconv.Atoi(strings.Fields(dateW.String())[0])
+	fields := strings.Fields(str[emailEnd+1:])
+	if len(fields) == 0 {
+		// some very old objects have no date at all
+		return sig, nil
+	}
+	timestamp, err := strconv.ParseInt(fields[0], 10, 64)
 	if err != nil {
-		return
+		return sig, err
 	}
 
-	timezone := strings.Fields(dateW.String())[1]
+	var offset int
+	if len(fields) > 1 {
+		sig.Offset = fields[1]
+		offset, err = parseOffset(sig.Offset)
+		if err != nil {
+			return sig, err
+		}
+	}
+	sig.When = time.Unix(timestamp, 0).In(time.FixedZone("", offset))
+	return sig, nil
+}
 
-	hours, err := strconv.Atoi(timezone)
+// parseOffset converts a timezone offset of the form +hhmm or -hhmm
+// into seconds east of UTC
+func parseOffset(str string) (int, error) {
+	if len(str) != 5 || (str[0] != '+' && str[0] != '-') {
+		return 0, fmt.Errorf("malformed timezone offset: %q", str)
+	}
+	hhmm, err := strconv.Atoi(str[1:])
 	if err != nil {
-		return
+		return 0, err
 	}
-	t := time.Unix(int64(timestamp), 0).In(time.FixedZone("", hours*60*60/100))
-	date, err = time.Parse(layout, fmt.Sprintf("%s %s", t.Format(layout2), timezone))
-
-	return strings.TrimSpace(authorW.String()), date, err
+	offset := (hhmm/100)*60*60 + (hhmm%100)*60
+	if str[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
 }
@@ -5,9 +5,8 @@ import (
 	"time"
 )
 
-func Test_parseAuthorString(t *testing.T) {
+func Test_parseSignature(t *testing.T) {
 	const input = "aditya <dev@chimeracoder.net> 1428349755 -0400"
-	const expectedAuthor = "aditya <dev@chimeracoder.net>"
 	loc, err := time.LoadLocation("America/New_York")
 	if err != nil {
 		t.Fatal(err)
@@ -15,14 +14,55 @@ func Test_parseAuthorString(t *testing.T) {
 
 	expectedDate := time.Unix(1428349755, 0)
 	expectedDate = expectedDate.In(loc)
-	author, date, err := parseAuthorString(input)
+	sig, err := parseSignature(input)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if author != expectedAuthor {
-		t.Errorf("expected author %s and received %s", expectedAuthor, author)
+	if sig.Name != "aditya" {
+		t.Errorf("expected name %s and received %s", "aditya", sig.Name)
 	}
-	if !expectedDate.Equal(date) {
-		t.Errorf("expected date %s and received %s", expectedDate, date)
+	if sig.Email != "dev@chimeracoder.net" {
+		t.Errorf("expected email %s and received %s", "dev@chimeracoder.net", sig.Email)
+	}
+	if !expectedDate.Equal(sig.When) {
+		t.Errorf("expected date %s and received %s", expectedDate, sig.When)
+	}
+	if _, offset := sig.When.Zone(); offset != -4*60*60 {
+		t.Errorf("expected offset %d and received %d", -4*60*60, offset)
+	}
+}
+
+func Test_SignatureString(t *testing.T) {
+	inputs := []string{
+		"aditya <dev@chimeracoder.net> 1428349755 -0400",
+		"aditya <dev@chimeracoder.net> 1428349755 -0000",
+		"aditya <dev@chimeracoder.net> 1428349755 +0530",
+		"aditya<dev@chimeracoder.net> 1428349755 +0000",
+		" <> 0 +0000",
+		"aditya <dev@chimeracoder.net>",
+	}
+	for _, input := range inputs {
+		sig, err := parseSignature(input)
+		if err != nil {
+			t.Errorf("%q: %s", input, err)
+			continue
+		}
+		if sig.String() != input {
+			t.Errorf("expected %q and received %q", input, sig.String())
+		}
+	}
+
+	sig, err := parseSignature("aditya <dev@chimeracoder.net> 1428349755 +0530")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, offset := sig.When.Zone(); offset != (5*60+30)*60 {
+		t.Errorf("expected offset %d and received %d", (5*60+30)*60, offset)
+	}
+
+	sig.Email = "aditya@example.com"
+	const expected = "aditya <aditya@example.com> 1428349755 +0530"
+	if sig.String() != expected {
+		t.Errorf("expected %q and received %q", expected, sig.String())
 	}
 }
@@ -0,0 +1,282 @@
+package gitgo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+const (
+	// DefaultPackWindow is the number of delta bases that
+	// NewPackWriter tries for each object, as in `git pack-objects`
+	DefaultPackWindow = 10
+
+	// DefaultPackDepth is the longest delta chain that
+	// NewPackWriter writes, as in `git pack-objects`
+	DefaultPackDepth = 50
+)
+
+// A PackWriter writes objects from a repository to a packfile and its index.
+// It is equivalent to `git pack-objects`: each object may be stored
+// as an OFS_DELTA against a similar object of the same type, if the delta
+// is smaller than the object itself.
+type PackWriter struct {
+	// Window is the number of other objects that are tried as delta bases
+	// for each object. If it is zero, every object is stored whole.
+	Window int
+
+	// Depth is the longest delta chain that will be written
+	Depth int
+
+	repo  *Repository
+	names []SHA
+	added map[SHA]bool
+}
+
+// packEntry is an object that is being written to a packfile
+type packEntry struct {
+	name  SHA
+	_type packObjectType
+	data  []byte
+
+	// base and delta are set if the object is stored as a delta,
+	// and depth is the length of its delta chain
+	base  *packEntry
+	delta []byte
+	depth int
+
+	// index is set while the object is being tried as a delta base
+	index *deltaIndex
+
+	offset  int64
+	written bool
+}
+
+// NewPackWriter returns a PackWriter that reads objects from the given repository,
+// using DefaultPackWindow and DefaultPackDepth
+func NewPackWriter(repo *Repository) *PackWriter {
+	return &PackWriter{
+		Window: DefaultPackWindow,
+		Depth:  DefaultPackDepth,
+		repo:   repo,
+		added:  map[SHA]bool{},
+	}
+}
+
+// Add adds objects to the pack. Objects that have already been added are ignored.
+// The objects are not read until Write is called.
+func (w *PackWriter) Add(names ...SHA) {
+	for _, name := range names {
+		if w.added[name] {
+			continue
+		}
+		w.added[name] = true
+		w.names = append(w.names, name)
+	}
+}
+
+// Write writes a version 2 packfile containing every object that has been added to pack,
+// and the corresponding version 2 index to idx. It returns the checksum of the packfile,
+// which git uses to name it: objects/pack/pack-<checksum>.pack
+//
+// Commits are written first, followed by tags, trees, and blobs, and otherwise
+// in the order in which they were added, except that a delta base
+// is always written before the objects that depend on it.
+// Every object is held in memory while the pack is written.
+func (w *PackWriter) Write(pack, idx io.Writer) (SHA, error) {
+	err := w.repo.load()
+	if err != nil {
+		return SHA{}, err
+	}
+	format := w.repo.format
+
+	entries := make([]*packEntry, len(w.names))
+	for i, name := range w.names {
+		_type, data, err := w.repo.readObject(name)
+		if err != nil {
+			return SHA{}, err
+		}
+		entries[i] = &packEntry{name: name, _type: _type, data: data}
+	}
+	if w.Window > 0 && w.Depth > 0 {
+		w.findDeltas(entries, format)
+	}
+
+	order := map[packObjectType]int{OBJ_COMMIT: 0, OBJ_TAG: 1, OBJ_TREE: 2, OBJ_BLOB: 3}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return order[entries[i]._type] < order[entries[j]._type]
+	})
+
+	h := format.New()
+	pw := &packWriter{w: io.MultiWriter(pack, h)}
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(entries)))
+	if _, err := pw.Write(header); err != nil {
+		return SHA{}, err
+	}
+
+	index := make([]indexEntry, 0, len(entries))
+	for _, entry := range entries {
+		index, err = pw.writeEntry(entry, index)
+		if err != nil {
+			return SHA{}, err
+		}
+	}
+
+	checksum := h.Sum(nil)
+	if _, err := pack.Write(checksum); err != nil {
+		return SHA{}, err
+	}
+	if err := writePackIndex(idx, format, index, checksum); err != nil {
+		return SHA{}, err
+	}
+	return newSHA(checksum), nil
+}
+
+// findDeltas chooses a delta base for each entry, if there is one that makes it smaller.
+// Entries are sorted by type and then by decreasing size, and each is compared
+// to the w.Window entries before it, so the base is usually the larger object
+// and the delta mostly consists of copies.
+func (w *PackWriter) findDeltas(entries []*packEntry, format ObjectFormat) {
+	candidates := append([]*packEntry(nil), entries...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i]._type != candidates[j]._type {
+			return candidates[i]._type < candidates[j]._type
+		}
+		return len(candidates[i].data) > len(candidates[j].data)
+	})
+
+	for i, target := range candidates {
+		// Only the entries in the window need to be indexed
+		if i > w.Window {
+			candidates[i-w.Window-1].index = nil
+		}
+		for j := i - 1; j >= 0 && j >= i-w.Window; j-- {
+			base := candidates[j]
+			if base._type != target._type {
+				break
+			}
+			if base.depth >= w.Depth {
+				continue
+			}
+
+			// A delta must be substantially smaller than the object to be worthwhile,
+			// since reading it requires reading the base as well
+			maxSize := len(target.data)/2 - format.Size()
+			if target.delta != nil {
+				maxSize = len(target.delta) - 1
+			}
+			// Every byte that the target has in addition to the base must be inserted
+			if maxSize <= 0 || len(target.data)-len(base.data) > maxSize {
+				continue
+			}
+
+			if base.index == nil {
+				base.index = newDeltaIndex(base.data)
+			}
+			delta := base.index.delta(target.data)
+			if len(delta) <= maxSize {
+				target.base, target.delta, target.depth = base, delta, base.depth+1
+			}
+		}
+	}
+}
+
+// packWriter writes entries to a packfile, keeping track of the current offset
+type packWriter struct {
+	w      io.Writer
+	offset int64
+}
+
+func (pw *packWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	return n, err
+}
+
+// writeEntry writes an entry to the packfile, after writing its delta base
+// if that has not been written yet, and appends them to the index
+func (pw *packWriter) writeEntry(entry *packEntry, index []indexEntry) ([]indexEntry, error) {
+	if entry.written {
+		return index, nil
+	}
+	if entry.base != nil && !entry.base.written {
+		var err error
+		index, err = pw.writeEntry(entry.base, index)
+		if err != nil {
+			return index, err
+		}
+	}
+
+	entry.offset = pw.offset
+	var header []byte
+	data := entry.data
+	if entry.base != nil {
+		data = entry.delta
+		header = appendEntryHeader(header, OBJ_OFS_DELTA, len(data))
+		header = appendOffsetDelta(header, entry.offset-entry.base.offset)
+	} else {
+		header = appendEntryHeader(header, entry._type, len(data))
+	}
+
+	crc, err := writeCompressedEntry(pw, header, data)
+	if err != nil {
+		return index, err
+	}
+	entry.written = true
+	return append(index, indexEntry{name: entry.name, offset: entry.offset, crc32: crc}), nil
+}
+
+// writeCompressedEntry writes the header of a packfile entry to w,
+// followed by the compressed data, and returns the CRC32 of everything written
+func writeCompressedEntry(w io.Writer, header, data []byte) (uint32, error) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+
+	crc := crc32.NewIEEE()
+	w = io.MultiWriter(w, crc)
+	if _, err := w.Write(header); err != nil {
+		return 0, err
+	}
+	if _, err := compressed.WriteTo(w); err != nil {
+		return 0, err
+	}
+	return crc.Sum32(), nil
+}
+
+// appendEntryHeader appends the header of a packfile entry, in the format read
+// by readPackObjectHeader: the type and the lowest four bits of the size,
+// followed by the rest of the size, seven bits at a time
+func appendEntryHeader(b []byte, _type packObjectType, size int) []byte {
+	c := byte(_type)<<4 | byte(size&15)
+	size >>= 4
+	for size > 0 {
+		b = append(b, c|128)
+		c = byte(size & 127)
+		size >>= 7
+	}
+	return append(b, c)
+}
+
+// appendOffsetDelta appends the distance from an OFS_DELTA entry back to its base,
+// in the format read by readOffsetDelta
+func appendOffsetDelta(b []byte, offset int64) []byte {
+	var buf [10]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(offset & 127)
+	for offset >>= 7; offset > 0; offset >>= 7 {
+		offset--
+		pos--
+		buf[pos] = byte(offset&127) | 128
+	}
+	return append(b, buf[pos:]...)
+}
@@ -0,0 +1,93 @@
+package gitgo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_PackWriter(t *testing.T) {
+	for _, dir := range []*os.File{RepoDir, SHA256RepoDir} {
+		src := &Repository{Basedir: *dir}
+		names := allObjects(t, src)
+
+		w := NewPackWriter(src)
+		w.Add(names...)
+		w.Add(names[0])
+		var pack, idx bytes.Buffer
+		checksum, err := w.Write(&pack, &idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		objects, err := verifyPack(bytes.NewReader(pack.Bytes()), bytes.NewReader(idx.Bytes()), src.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Some objects are both loose and packed
+		if len(objects) != len(w.names) {
+			t.Errorf("expected %d objects and received %d", len(w.names), len(objects))
+		}
+		var deltas int
+		for _, obj := range objects {
+			if obj._type == OBJ_OFS_DELTA {
+				deltas++
+			}
+		}
+		if deltas == 0 {
+			t.Errorf("expected some objects to be stored as deltas")
+		}
+
+		// Install the pack in an empty repository, and read every object from it
+		tmp, dst := tempRepo(t)
+		defer os.RemoveAll(tmp)
+		dst.format = src.format
+		packPath := filepath.Join(dst.Basedir.Name(), "objects", "pack", "pack-"+checksum.String())
+		if err := ioutil.WriteFile(packPath+".pack", pack.Bytes(), 0444); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(packPath+".idx", idx.Bytes(), 0444); err != nil {
+			t.Fatal(err)
+		}
+		if err := dst.load(); err != nil {
+			t.Fatal(err)
+		}
+		dst.Verify = true
+		for _, name := range names {
+			expectedType, expected, err := src.readObject(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_type, data, err := dst.readObject(name)
+			if err != nil {
+				t.Errorf("error reading %s: %s", name, err)
+				continue
+			}
+			if _type != expectedType || !bytes.Equal(data, expected) {
+				t.Errorf("object %s does not match", name)
+			}
+		}
+	}
+}
+
+func Test_PackWriterNoDeltas(t *testing.T) {
+	src := &Repository{Basedir: *RepoDir}
+	w := NewPackWriter(src)
+	w.Window = 0
+	w.Add(allObjects(t, src)...)
+	var pack, idx bytes.Buffer
+	if _, err := w.Write(&pack, &idx); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := VerifyPack(bytes.NewReader(pack.Bytes()), bytes.NewReader(idx.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objects {
+		if obj._type == OBJ_OFS_DELTA {
+			t.Errorf("expected %s to be stored whole", obj.Name)
+		}
+	}
+}
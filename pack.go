@@ -1,8 +1,10 @@
 package gitgo
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -12,32 +14,162 @@ import (
 
 type packfile struct {
 	basedir os.File
-	name    SHA
-	objects map[SHA]*packObject
+	name    string
+	format  ObjectFormat
+
+	// idx is used to find objects within the packfile,
+	// so that only the objects that are requested need to be read
+	idx *packIndex
+
+	// repo is used to find the bases of REF_DELTA objects
+	// that are not stored in the packfile itself
+	repo *Repository
+}
+
+// path returns the path of the packfile's file with the given extension
+func (p *packfile) path(ext string) string {
+	return filepath.Join(p.basedir.Name(), "objects", "pack", p.name+ext)
 }
 
-func (p *packfile) verify() error {
-	if p.objects == nil {
-		p.objects = map[SHA]*packObject{}
+// maxDeltaDepth is the longest delta chain that will be followed.
+// git never writes chains longer than 4095, so a longer chain
+// indicates a malformed packfile (or a cycle).
+const maxDeltaDepth = 4095
+
+// find returns the offset of the object with the given name, if it is in the packfile
+func (p *packfile) find(name SHA) (int64, bool, error) {
+	i, ok := p.idx.find(name)
+	if !ok {
+		return 0, false, nil
 	}
-	packf, err := os.Open(filepath.Join(p.basedir.Name(), "objects", "pack", string(p.name)+".pack"))
+	offset, err := p.idx.offset(i)
+	return offset, true, err
+}
+
+// object reads the object at the given offset in the packfile,
+// along with any delta bases that it depends on
+func (p *packfile) object(name SHA, offset int64) (*packObject, error) {
+	f, err := os.Open(p.path(".pack"))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer packf.Close()
-	idxf, err := os.Open(filepath.Join(p.basedir.Name(), "objects", "pack", string(p.name)+".idx"))
+	defer f.Close()
+	return p.objectAt(f, name, offset, 0)
+}
+
+func (p *packfile) objectAt(f io.ReadSeeker, name SHA, offset int64, depth int) (*packObject, error) {
+	if depth > maxDeltaDepth {
+		return nil, fmt.Errorf("delta chain for %s is too long", name)
+	}
+
+	// Delta bases are cached, since other objects in the
+	// same delta chain are likely to be read as well
+	var cache *deltaBaseCache
+	if depth > 0 && p.repo != nil {
+		cache = p.repo.cache
+	}
+	if cache != nil {
+		if entry, ok := cache.get(p, offset); ok {
+			return &packObject{Name: name, Offset: int(offset), _type: entry._type, BaseObjectType: entry._type, PatchedData: entry.data, Depth: entry.depth}, nil
+		}
+	}
+
+	obj, r, err := p.readEntry(f, offset)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer idxf.Close()
-	objs, err := VerifyPack(packf, idxf)
+	obj.Name = name
+	obj.Data, err = inflate(r, obj.Size)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, obj := range objs {
-		p.objects[obj.Name] = obj
+	if obj._type < OBJ_OFS_DELTA {
+		obj.PatchedData = obj.Data
+		obj.BaseObjectType = obj._type
+		if cache != nil {
+			cache.add(p, offset, obj.BaseObjectType, obj.PatchedData, obj.Depth)
+		}
+		return obj, nil
 	}
-	return nil
+
+	var base *packObject
+	switch obj._type {
+	case OBJ_OFS_DELTA:
+		base, err = p.objectAt(f, SHA{}, int64(obj.baseOffset), depth+1)
+	case OBJ_REF_DELTA:
+		var baseOffset int64
+		var ok bool
+		baseOffset, ok, err = p.find(obj.BaseObjectName)
+		switch {
+		case err != nil:
+		case ok:
+			base, err = p.objectAt(f, obj.BaseObjectName, baseOffset, depth+1)
+		case p.repo != nil:
+			// The base is stored elsewhere in the repository,
+			// as it is for a thin pack
+			base, err = p.repo.deltaBase(obj.BaseObjectName, p, depth+1)
+		default:
+			err = fmt.Errorf("base object not in packfile: %s", obj.BaseObjectName)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	patched, err := patchDelta(bytes.NewReader(base.PatchedData), bytes.NewReader(obj.Data))
+	if err != nil {
+		return nil, err
+	}
+	obj.PatchedData, err = ioutil.ReadAll(patched)
+	if err != nil {
+		return nil, err
+	}
+	obj.BaseObjectType = base.BaseObjectType
+	obj.Depth = base.Depth + 1
+	if cache != nil {
+		cache.add(p, offset, obj.BaseObjectType, obj.PatchedData, obj.Depth)
+	}
+	return obj, nil
+}
+
+// readEntry reads the header of the packfile entry at the given offset,
+// including the location of the delta base, if the entry is a delta.
+// The returned reader is positioned at the start of the compressed data.
+func (p *packfile) readEntry(f io.ReadSeeker, offset int64) (*packObject, *bufio.Reader, error) {
+	_, err := f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(f)
+	_type, size, err := readPackObjectHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj := &packObject{Offset: int(offset), _type: _type, Size: size}
+
+	switch _type {
+	case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+		obj.BaseObjectType = _type
+	case OBJ_OFS_DELTA:
+		negativeOffset, err := readOffsetDelta(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if negativeOffset <= 0 || negativeOffset > offset {
+			return nil, nil, fmt.Errorf("invalid delta base offset %d for object at offset %d", negativeOffset, offset)
+		}
+		obj.negativeOffset = int(negativeOffset)
+		obj.baseOffset = int(offset - negativeOffset)
+	case OBJ_REF_DELTA:
+		baseName := make([]byte, p.format.Size())
+		if _, err := io.ReadFull(r, baseName); err != nil {
+			return nil, nil, err
+		}
+		obj.BaseObjectName = newSHA(baseName)
+	default:
+		return nil, nil, fmt.Errorf("invalid object type %d at offset %d", _type, offset)
+	}
+	return obj, r, nil
 }
 
 type packObject struct {
@@ -62,22 +194,30 @@ type packObject struct {
 }
 
 func (p *packObject) Type() string {
-	switch p.BaseObjectType {
+	return p.BaseObjectType.objectType()
+}
+
+// objectType returns the name that git uses for the type,
+// eg "commit" for OBJ_COMMIT
+func (t packObjectType) objectType() string {
+	switch t {
 	case OBJ_COMMIT:
 		return "commit"
 	case OBJ_TREE:
 		return "tree"
 	case OBJ_BLOB:
 		return "blob"
+	case OBJ_TAG:
+		return "tag"
 	default:
-		return p.BaseObjectType.String()
+		return t.String()
 	}
 }
 
 // normalize returns a GitObject equivalent to the packObject.
 // packObject satisfies the GitObject interface, but if the pack
-// object type is a commit, tree, or blob, it will return a Commit,
-// Tree, or Blob struct instead of the packObject
+// object type is a commit, tree, blob, or tag, it will return a Commit,
+// Tree, Blob, or Tag struct instead of the packObject
 func (p *packObject) normalize(basedir os.File) (GitObject, error) {
 	switch p.BaseObjectType {
 	case OBJ_COMMIT:
@@ -86,6 +226,8 @@ func (p *packObject) normalize(basedir os.File) (GitObject, error) {
 		return p.Tree(basedir)
 	case OBJ_BLOB:
 		return p.Blob(basedir)
+	case OBJ_TAG:
+		return p.Tag(basedir)
 	default:
 		return p, nil
 	}
@@ -114,7 +256,7 @@ func (p *packObject) Tree(basedir os.File) (Tree, error) {
 		p.PatchedData = p.Data
 	}
 
-	tree, err := parseTree(bytes.NewReader(p.PatchedData), strconv.Itoa(p.Size), basedir)
+	tree, err := parseTree(bytes.NewReader(p.PatchedData), strconv.Itoa(p.Size), p.Name.Format())
 	return tree, err
 }
 
@@ -133,10 +275,26 @@ func (p *packObject) Blob(basedir os.File) (Blob, error) {
 	return blob, err
 }
 
+// Tag returns a Tag struct for the packObject.
+func (p *packObject) Tag(basedir os.File) (Tag, error) {
+	if p.BaseObjectType != OBJ_TAG {
+		return Tag{}, fmt.Errorf("pack object is not a tag: %s", p.Type())
+	}
+	if p.PatchedData == nil {
+		p.PatchedData = p.Data
+	}
+
+	return parseTag(bytes.NewReader(p.PatchedData), strconv.Itoa(p.Size), p.Name)
+}
+
 func (p *packObject) Patch(dict map[SHA]*packObject) error {
 	if len(p.PatchedData) != 0 {
 		return nil
 	}
+	if p.err != nil {
+		// The object, or its own base, could not be read
+		return p.err
+	}
 	if p._type < OBJ_OFS_DELTA {
 		if p.Data == nil {
 			return fmt.Errorf("base object data is nil")
@@ -180,6 +338,22 @@ func (p *packObject) PatchedType() packObjectType {
 	return p.BaseObjectType
 }
 
+// parsePackObjectType returns the type of a commit, tree, blob, or tag
+func parsePackObjectType(objType string) (packObjectType, error) {
+	switch objType {
+	case "commit":
+		return OBJ_COMMIT, nil
+	case "tree":
+		return OBJ_TREE, nil
+	case "blob":
+		return OBJ_BLOB, nil
+	case "tag":
+		return OBJ_TAG, nil
+	default:
+		return 0, fmt.Errorf("invalid object type %s", objType)
+	}
+}
+
 //go:generate stringer -type=packObjectType
 type packObjectType uint8
 
@@ -200,23 +374,22 @@ func (r *Repository) listPackfiles() ([]*packfile, error) {
 	if err != nil {
 		return nil, err
 	}
-	packfileNames := []SHA{}
+	packfileNames := []string{}
 	for _, file := range files {
 		base := strings.TrimSuffix(file.Name(), ".pack")
 		if base == file.Name() {
 			// this wasn't a packfile
 			continue
 		}
-		packfileNames = append(packfileNames, SHA(base))
+		packfileNames = append(packfileNames, base)
 	}
 	packs := make([]*packfile, len(packfileNames))
 	for i, n := range packfileNames {
-		p := &packfile{basedir: basedir, name: n}
-		err = p.verify()
+		idx, err := readPackIndex(filepath.Join(basedir.Name(), "objects", "pack", n+".idx"), r.format)
 		if err != nil {
 			return nil, err
 		}
-		packs[i] = p
+		packs[i] = &packfile{basedir: basedir, name: n, format: r.format, idx: idx, repo: r}
 	}
 	return packs, nil
 }
@@ -0,0 +1,96 @@
+package gitgo
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_RefDelta(t *testing.T) {
+	repo := Repository{Basedir: *RefDeltaRepoDir, Verify: true}
+
+	cases := []struct {
+		name string
+		base string
+	}{
+		// the base is in the same packfile
+		{"4223f1a8c57a281d5e41c329a4f983a7bb3b57a0", "873285b0320556fcd55b029aacbb7b2fe724a6fd"},
+		// thin pack: the base is a loose object
+		{"835657c7f5c7513fa20a201ff1ca0b01f75312fd", "d9d199cb5da0000deff17cc5dcac76a17e682384"},
+		// thin pack: the base is in another packfile
+		{"f941086d4c84a3b159f7361e23513057736e3cd9", "873285b0320556fcd55b029aacbb7b2fe724a6fd"},
+	}
+	for _, c := range cases {
+		name := mustSHA(c.name)
+		// With r.Verify set, the patched object must hash to its name
+		obj, err := repo.Object(name)
+		if err != nil {
+			t.Errorf("could not read %s: %s", name, err)
+			continue
+		}
+		if obj.Type() != "blob" {
+			t.Errorf("expected %s to be a blob and received a %s", name, obj.Type())
+		}
+
+		pack, offset, ok, err := repo.findPacked(name)
+		if err != nil || !ok {
+			t.Fatalf("%s is not packed (%v)", name, err)
+		}
+		f := mustOpen(t, pack.path(".pack"))
+		entry, _, err := pack.readEntry(f, offset)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry._type != OBJ_REF_DELTA || entry.BaseObjectName != mustSHA(c.base) {
+			t.Errorf("expected %s to be a REF_DELTA against %s and received %s against %s", name, c.base, entry._type, entry.BaseObjectName)
+		}
+	}
+
+	commits, err := Log(mustSHA("c006098c7700a514c5a1e71fd79ec251371d144e"), RefDeltaRepoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 4 {
+		t.Errorf("expected 4 commits and received %d", len(commits))
+	}
+
+	corrupt, err := repo.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range corrupt {
+		t.Errorf("unexpected corrupt object: %s", c)
+	}
+}
+
+func Test_VerifyPackRefDelta(t *testing.T) {
+	const packName = "pack-fe348c216ae0e203daa9f346aa06ee17615e5444"
+	packFile := mustOpen(t, path.Join(RefDeltaRepoDir.Name(), "objects", "pack", packName+".pack"))
+	defer packFile.Close()
+	idxFile := mustOpen(t, path.Join(RefDeltaRepoDir.Name(), "objects", "pack", packName+".idx"))
+	defer idxFile.Close()
+
+	objects, err := VerifyPack(packFile, idxFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objects {
+		if obj.err != nil {
+			t.Errorf("error reading %s: %s", obj.Name, obj.err)
+		}
+		if obj.Name == mustSHA("4223f1a8c57a281d5e41c329a4f983a7bb3b57a0") {
+			if obj.BaseObjectName != mustSHA("873285b0320556fcd55b029aacbb7b2fe724a6fd") || obj.Type() != "blob" {
+				t.Errorf("ref delta was not resolved: %+v", obj)
+			}
+		}
+	}
+}
+
+func mustOpen(t *testing.T, filename string) *os.File {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
@@ -1,24 +1,41 @@
 package gitgo
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
 )
 
 type Repository struct {
-	Basedir   os.File
+	Basedir os.File
+
+	// If Verify is set, every object that is read is hashed and compared
+	// to its name, and a *CorruptObjectError is returned if they differ.
+	// This is slower, but detects objects that are damaged or tampered with.
+	Verify bool
+
+	// DeltaBaseCacheLimit is the most memory, in bytes, used to cache
+	// the contents of delta bases read from packfiles. If it is zero,
+	// core.deltaBaseCacheLimit from the repository's config is used,
+	// or DefaultDeltaBaseCacheLimit if that is not set either.
+	// If it is negative, nothing is cached. It must be set before
+	// the repository is first used.
+	DeltaBaseCacheLimit int64
+
 	packfiles []*packfile
+	midx      *multiPackIndex
+	graph     *commitGraph
+	cache     *deltaBaseCache
+
+	// format is the hash algorithm used for object names
+	format ObjectFormat
 }
 
 func (r *Repository) Object(input SHA) (obj GitObject, err error) {
-	err = r.normalizeBasename()
-	if r.packfiles == nil {
-		packfiles, err := r.listPackfiles()
-		if err != nil {
-			return nil, err
-		}
-		r.packfiles = packfiles
+	err = r.load()
+	if err != nil {
+		return nil, err
 	}
 	basedir := &r.Basedir
 	if filepath.Base(basedir.Name()) != ".git" {
@@ -29,10 +46,218 @@ func (r *Repository) Object(input SHA) (obj GitObject, err error) {
 			return nil, err
 		}
 	}
-	obj, err = newObject(input, basedir, r.packfiles)
+	obj, err = newObject(r, input, basedir)
 	return obj, err
 }
 
+// load locates the repository and reads the list of packfiles,
+// along with the multi-pack-index and commit-graph, if that has not been done already
+func (r *Repository) load() error {
+	err := r.normalizeBasename()
+	if err != nil {
+		return err
+	}
+	if r.format == "" || r.cache == nil {
+		cfg, err := readConfig(r.Basedir.Name())
+		if err != nil {
+			return err
+		}
+		if r.format == "" {
+			r.format, err = cfg.objectFormat()
+			if err != nil {
+				return err
+			}
+		}
+		if r.cache == nil {
+			limit := r.DeltaBaseCacheLimit
+			if limit == 0 {
+				limit, err = cfg.deltaBaseCacheLimit()
+				if err != nil {
+					return err
+				}
+			}
+			r.cache = newDeltaBaseCache(limit)
+		}
+	}
+	if r.packfiles == nil {
+		packfiles, err := r.listPackfiles()
+		if err != nil {
+			return err
+		}
+		r.packfiles = packfiles
+
+		// The multi-pack-index only makes lookups faster, so as in git,
+		// one that cannot be read is ignored, and each packfile's own index
+		// is searched instead. Likewise, a commit-graph that cannot be read
+		// is ignored, and commits are read instead.
+		r.midx, err = r.readMultiPackIndex()
+		if err != nil {
+			r.midx = nil
+		}
+		r.graph, err = r.readCommitGraph()
+		if err != nil {
+			r.graph = nil
+		}
+	}
+	return nil
+}
+
+// ObjectFormat returns the hash algorithm that the repository
+// uses to name its objects
+func (r *Repository) ObjectFormat() (ObjectFormat, error) {
+	err := r.load()
+	if err != nil {
+		return "", err
+	}
+	return r.format, nil
+}
+
+// findPacked finds the object with the given name in the repository's packfiles,
+// and returns the packfile along with the offset of the object within it.
+// If the repository has a multi-pack-index, it is searched first, followed by
+// any packfiles that it does not cover.
+// It does not accept abbreviated names.
+func (r *Repository) findPacked(name SHA) (pack *packfile, offset int64, ok bool, err error) {
+	if r.midx != nil {
+		if i, ok := r.midx.find(name); ok {
+			pack, offset, err := r.midx.location(i)
+			return pack, offset, err == nil, err
+		}
+	}
+	for _, pack := range r.packfiles {
+		if r.midx.covers(pack) {
+			continue
+		}
+		offset, ok, err := pack.find(name)
+		if ok || err != nil {
+			return pack, offset, ok, err
+		}
+	}
+	return nil, 0, false, nil
+}
+
+// deltaBase reads the base of a REF_DELTA object in the given packfile,
+// when the base is not stored in that packfile. It may be stored
+// in another packfile, or as a loose object.
+func (r *Repository) deltaBase(name SHA, from *packfile, depth int) (*packObject, error) {
+	if depth > maxDeltaDepth {
+		return nil, fmt.Errorf("delta chain for %s is too long", name)
+	}
+	// The base is not in the packfile that refers to it,
+	// so it cannot be found there
+	pack, offset, ok, err := r.findPacked(name)
+	if err != nil {
+		return nil, err
+	}
+	if ok && pack == from {
+		// The multi-pack-index lists the base in that packfile,
+		// but another packfile may contain it as well
+		ok = false
+		for _, p := range r.packfiles {
+			if p == from {
+				continue
+			}
+			offset, ok, err = p.find(name)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				pack = p
+				break
+			}
+		}
+	}
+	if ok {
+		f, err := os.Open(pack.path(".pack"))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return pack.objectAt(f, name, offset, depth)
+	}
+
+	objType, data, err := readLooseObject(loosePath(r.Basedir.Name(), name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("delta base not found: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_type, err := parsePackObjectType(objType)
+	if err != nil {
+		return nil, err
+	}
+	return &packObject{Name: name, _type: _type, BaseObjectType: _type, Size: len(data), Data: data, PatchedData: data}, nil
+}
+
+// readObject returns the type and contents of the object with the given name,
+// whether it is packed or loose. Deltas are resolved, so the type
+// is always a commit, tree, blob, or tag.
+func (r *Repository) readObject(name SHA) (packObjectType, []byte, error) {
+	pack, offset, ok, err := r.findPacked(name)
+	if err != nil {
+		return 0, nil, err
+	}
+	if ok {
+		obj, err := pack.object(name, offset)
+		if err != nil {
+			return 0, nil, err
+		}
+		if r.Verify {
+			if err := pack.checkObject(obj); err != nil {
+				return 0, nil, err
+			}
+		}
+		return obj.BaseObjectType, obj.PatchedData, nil
+	}
+
+	filename := loosePath(r.Basedir.Name(), name)
+	objType, data, err := readLooseObject(filename)
+	if os.IsNotExist(err) {
+		return 0, nil, fmt.Errorf("object not found: %s", name)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	_type, err := parsePackObjectType(objType)
+	if err != nil {
+		return 0, nil, err
+	}
+	if r.Verify {
+		h := name.Format().New()
+		writeObject(h, objType, bytes.NewReader(data), int64(len(data)))
+		if actual := newSHA(h.Sum(nil)); actual != name {
+			return 0, nil, &CorruptObjectError{Name: name, Actual: actual, Path: filename, Offset: -1}
+		}
+	}
+	return _type, data, nil
+}
+
+// PeelTag follows an annotated tag (and any tags it points to)
+// until it reaches the commit that it ultimately refers to.
+func (r *Repository) PeelTag(tag Tag) (Commit, error) {
+	seen := map[SHA]bool{}
+	for {
+		if seen[tag.Name] {
+			return Commit{}, fmt.Errorf("tag cycle detected at %s", tag.Name)
+		}
+		seen[tag.Name] = true
+
+		obj, err := r.Object(tag.Object)
+		if err != nil {
+			return Commit{}, err
+		}
+		switch obj := obj.(type) {
+		case Commit:
+			return obj, nil
+		case Tag:
+			tag = obj
+		default:
+			return Commit{}, fmt.Errorf("tag %s points to a %s, not a commit", tag.Name, obj.Type())
+		}
+	}
+}
+
 func (r *Repository) normalizeBasename() error {
 	var err error
 	candidate := &r.Basedir
@@ -105,5 +330,4 @@ func findGitDir(pwd *os.File) (dir *os.File, err error) {
 		candidateName, err = filepath.Abs(filepath.Join(candidateName, "..", "..", ".git"))
 		candidate.Close()
 	}
-	return nil, fmt.Errorf("could not find the git repository")
 }
@@ -8,16 +8,31 @@ import (
 
 var RepoDir *os.File
 
-func init() {
+// SHA256RepoDir is a repository that uses SHA-256 object names
+var SHA256RepoDir *os.File
 
-	_, err := os.Stat(path.Join("test_data", ".git"))
-	if err != nil {
-		if !os.IsNotExist(err) {
-			log.Fatal(err)
-		}
-		err := os.Symlink(path.Join("dot_git"), path.Join("test_data", ".git"))
+// RefDeltaRepoDir is a repository whose packfiles use REF_DELTA
+// instead of OFS_DELTA. Two of them are thin packs, whose delta bases
+// are stored in another packfile or as loose objects.
+var RefDeltaRepoDir *os.File
+
+// CommitGraphRepoDir is a repository with an octopus merge, non-ASCII paths,
+// and commit times that need the largest fields in a commit-graph.
+// It has no commit-graph, but test_data/commit-graph holds the files that
+// git writes for it, with and without changed-path Bloom filters.
+var CommitGraphRepoDir *os.File
+
+func init() {
+	for _, dir := range []string{"test_data", path.Join("test_data", "sha256"), path.Join("test_data", "ref-delta"), path.Join("test_data", "commit-graph")} {
+		_, err := os.Stat(path.Join(dir, ".git"))
 		if err != nil {
-			log.Fatal(err)
+			if !os.IsNotExist(err) {
+				log.Fatal(err)
+			}
+			err := os.Symlink(path.Join("dot_git"), path.Join(dir, ".git"))
+			if err != nil {
+				log.Fatal(err)
+			}
 		}
 	}
 }
@@ -29,4 +44,27 @@ func init() {
 		panic(err)
 	}
 
+	SHA256RepoDir, err = os.Open(path.Join("test_data", "sha256", ".git"))
+	if err != nil {
+		panic(err)
+	}
+
+	RefDeltaRepoDir, err = os.Open(path.Join("test_data", "ref-delta", ".git"))
+	if err != nil {
+		panic(err)
+	}
+
+	CommitGraphRepoDir, err = os.Open(path.Join("test_data", "commit-graph", ".git"))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// mustSHA parses a full object name, and panics if it is invalid
+func mustSHA(s string) SHA {
+	sha, err := ParseSHA(s)
+	if err != nil {
+		panic(err)
+	}
+	return sha
 }
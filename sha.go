@@ -0,0 +1,278 @@
+package gitgo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SHA is the name of a git object: the hash of its contents.
+// Depending on the repository's ObjectFormat, this is either
+// a 20-byte SHA-1 hash or a 32-byte SHA-256 hash.
+// The zero value does not name any object.
+type SHA struct {
+	hash [sha256.Size]byte
+	size uint8
+}
+
+// minPrefixLength is the shortest abbreviated name that git will accept
+const minPrefixLength = 4
+
+// newSHA returns the object name with the given raw bytes,
+// which must be the size of a SHA-1 or SHA-256 hash
+func newSHA(b []byte) SHA {
+	var sha SHA
+	sha.size = uint8(copy(sha.hash[:], b))
+	return sha
+}
+
+// ParseSHA parses a full object name, written as either 40 (SHA-1)
+// or 64 (SHA-256) hexadecimal characters.
+// Abbreviated names must be resolved with Repository.ResolvePrefix.
+func ParseSHA(s string) (SHA, error) {
+	var sha SHA
+	if len(s) != SHA1.hexSize() && len(s) != SHA256.hexSize() {
+		return sha, fmt.Errorf("invalid object name %q: must be %d or %d hexadecimal characters", s, SHA1.hexSize(), SHA256.hexSize())
+	}
+	n, err := hex.Decode(sha.hash[:], []byte(s))
+	if err != nil {
+		return SHA{}, fmt.Errorf("invalid object name %q: %s", s, err)
+	}
+	sha.size = uint8(n)
+	return sha, nil
+}
+
+// Bytes returns the raw bytes of the object name
+func (s SHA) Bytes() []byte {
+	return s.hash[:s.size]
+}
+
+// Format returns the object format that the name belongs to,
+// based on its length
+func (s SHA) Format() ObjectFormat {
+	if int(s.size) == SHA256.Size() {
+		return SHA256
+	}
+	return SHA1
+}
+
+// String returns the object name as hexadecimal
+func (s SHA) String() string {
+	return hex.EncodeToString(s.Bytes())
+}
+
+// IsZero reports whether s is the zero value, which does not name any object
+func (s SHA) IsZero() bool {
+	return s.hash == [sha256.Size]byte{}
+}
+
+// MarshalText encodes the object name as hexadecimal
+func (s SHA) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a full hexadecimal object name
+func (s *SHA) UnmarshalText(text []byte) error {
+	sha, err := ParseSHA(string(text))
+	if err != nil {
+		return err
+	}
+	*s = sha
+	return nil
+}
+
+// ObjectFormat is the hash algorithm that a repository uses to name its objects.
+// It is set by the extensions.objectFormat option in the repository's config.
+type ObjectFormat string
+
+const (
+	SHA1   ObjectFormat = "sha1"
+	SHA256 ObjectFormat = "sha256"
+)
+
+// Size returns the length of an object name, in bytes
+func (f ObjectFormat) Size() int {
+	if f == SHA256 {
+		return sha256.Size
+	}
+	return sha1.Size
+}
+
+// hexSize returns the length of an object name written as hexadecimal
+func (f ObjectFormat) hexSize() int {
+	return hex.EncodedLen(f.Size())
+}
+
+// New returns a hash.Hash that computes object names in this format
+func (f ObjectFormat) New() hash.Hash {
+	if f == SHA256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+// ErrAmbiguous is the error matched by an *AmbiguousError
+var ErrAmbiguous = errors.New("ambiguous object name")
+
+// AmbiguousError is returned when an abbreviated object name
+// matches more than one object.
+type AmbiguousError struct {
+	Prefix     string
+	Candidates []SHA
+}
+
+func (e *AmbiguousError) Error() string {
+	names := make([]string, len(e.Candidates))
+	for i, c := range e.Candidates {
+		names[i] = c.String()
+	}
+	return fmt.Sprintf("short object name %s is ambiguous; candidates are %s", e.Prefix, strings.Join(names, ", "))
+}
+
+// Is allows errors.Is(err, ErrAmbiguous) to match an *AmbiguousError
+func (e *AmbiguousError) Is(target error) bool {
+	return target == ErrAmbiguous
+}
+
+// ResolvePrefix returns the full name of the object whose name begins with prefix.
+// It considers both loose and packed objects. If more than one object matches,
+// it returns an *AmbiguousError listing every candidate.
+func (r *Repository) ResolvePrefix(prefix string) (SHA, error) {
+	err := r.load()
+	if err != nil {
+		return SHA{}, err
+	}
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < minPrefixLength || len(prefix) > r.format.hexSize() {
+		return SHA{}, fmt.Errorf("invalid object name %q: must be between %d and %d characters", prefix, minPrefixLength, r.format.hexSize())
+	}
+	if strings.Trim(prefix, "0123456789abcdef") != "" {
+		return SHA{}, fmt.Errorf("invalid object name %q: must be hexadecimal", prefix)
+	}
+
+	candidates, err := r.objectsWithPrefix(prefix)
+	if err != nil {
+		return SHA{}, err
+	}
+	switch len(candidates) {
+	case 0:
+		return SHA{}, fmt.Errorf("object not found: %s", prefix)
+	case 1:
+		return candidates[0], nil
+	default:
+		return SHA{}, &AmbiguousError{Prefix: prefix, Candidates: candidates}
+	}
+}
+
+// Abbreviate returns the shortest prefix of name, at least minLength characters long,
+// that does not match any other object in the repository. This is equivalent
+// to the %h format used by git, which uses a minLength of 7 by default.
+func (r *Repository) Abbreviate(name SHA, minLength int) (string, error) {
+	if minLength < minPrefixLength {
+		minLength = minPrefixLength
+	}
+	full := name.String()
+	if minLength > len(full) {
+		minLength = len(full)
+	}
+
+	// Every other object whose name shares at least the first two
+	// characters is either in the same loose object directory or in a packfile
+	others, err := r.objectsWithPrefix(full[:2])
+	if err != nil {
+		return "", err
+	}
+	length := minLength
+	for _, other := range others {
+		if other == name {
+			continue
+		}
+		common := commonPrefixLength(full, other.String())
+		if common+1 > length {
+			length = common + 1
+		}
+	}
+	return full[:length], nil
+}
+
+func commonPrefixLength(a, b string) int {
+	var i int
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// objectsWithPrefix returns the sorted names of all loose and packed objects
+// whose hexadecimal names begin with prefix, which must be at least two characters long.
+func (r *Repository) objectsWithPrefix(prefix string) ([]SHA, error) {
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[SHA]bool{}
+	files, err := ioutil.ReadDir(filepath.Join(r.Basedir.Name(), "objects", prefix[:2]))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), prefix[2:]) {
+			continue
+		}
+		name, err := ParseSHA(prefix[:2] + file.Name())
+		if err != nil {
+			// not an object (eg, a temporary file)
+			continue
+		}
+		found[name] = true
+	}
+
+	first, err := hex.DecodeString(prefix[:2])
+	if err != nil {
+		return nil, err
+	}
+	if r.midx != nil {
+		start, end := r.midx.bucket(first[0])
+		for i := start; i < end; i++ {
+			if name := r.midx.name(i); strings.HasPrefix(name.String(), prefix) {
+				found[name] = true
+			}
+		}
+	}
+	for _, pack := range r.packfiles {
+		if r.midx.covers(pack) {
+			continue
+		}
+		start, end := pack.idx.bucket(first[0])
+		for i := start; i < end; i++ {
+			if name := pack.idx.name(i); strings.HasPrefix(name.String(), prefix) {
+				found[name] = true
+			}
+		}
+	}
+
+	result := make([]SHA, 0, len(found))
+	for name := range found {
+		result = append(result, name)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i].Bytes(), result[j].Bytes()) < 0
+	})
+	return result, nil
+}
+
+// loosePath returns the path at which the object would be stored as a loose object
+func loosePath(basedir string, name SHA) string {
+	s := name.String()
+	return filepath.Join(basedir, "objects", s[:2], s[2:])
+}
@@ -0,0 +1,218 @@
+package gitgo
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ParseSHA(t *testing.T) {
+	const input = "af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"
+	sha, err := ParseSHA(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha.String() != input {
+		t.Errorf("expected %s and received %s", input, sha)
+	}
+
+	for _, invalid := range []string{"", "af6e4fe", input + "00", "zf6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"} {
+		if _, err := ParseSHA(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func Test_ResolvePrefix(t *testing.T) {
+	repo := Repository{Basedir: *RepoDir}
+
+	// af6e4fe is a loose object, and b45377f is packed
+	for _, expected := range []SHA{mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"), mustSHA("b45377f6daf59a4cec9e8de64f5df1533a7994cd")} {
+		name, err := repo.ResolvePrefix(strings.ToUpper(expected.String()[:7]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != expected {
+			t.Errorf("expected %s and received %s", expected, name)
+		}
+	}
+
+	for _, invalid := range []string{"af6", "af6e4fg", "0000000"} {
+		if _, err := repo.ResolvePrefix(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func Test_ResolvePrefixAmbiguous(t *testing.T) {
+	dir, repo := tempRepo(t)
+	defer os.RemoveAll(dir)
+
+	// These two blobs share the first four characters of their names
+	var candidates []SHA
+	for _, contents := range []string{"file 162\n", "file 402\n"} {
+		name, err := repo.WriteObject("blob", strings.NewReader(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		candidates = append(candidates, name)
+	}
+
+	_, err := repo.ResolvePrefix("f735")
+	if !errors.Is(err, ErrAmbiguous) {
+		t.Fatalf("expected an ambiguous name error and received %v", err)
+	}
+	var ambiguous *AmbiguousError
+	if !errors.As(err, &ambiguous) {
+		t.Fatalf("expected an *AmbiguousError and received %T", err)
+	}
+	if !reflect.DeepEqual(ambiguous.Candidates, candidates) {
+		t.Errorf("expected candidates %v and received %v", candidates, ambiguous.Candidates)
+	}
+
+	abbrev, err := repo.Abbreviate(candidates[0], 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if abbrev != "f735a" {
+		t.Errorf("expected f735a and received %s", abbrev)
+	}
+	if name, err := repo.ResolvePrefix(abbrev); err != nil || name != candidates[0] {
+		t.Errorf("expected %s and received %s (%v)", candidates[0], name, err)
+	}
+}
+
+func Test_Abbreviate(t *testing.T) {
+	repo := Repository{Basedir: *RepoDir}
+	abbrev, err := repo.Abbreviate(mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if abbrev != "af6e4fe" {
+		t.Errorf("expected af6e4fe and received %s", abbrev)
+	}
+}
+
+func Test_SHA256Repository(t *testing.T) {
+	repo := Repository{Basedir: *SHA256RepoDir}
+	format, err := repo.ObjectFormat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != SHA256 {
+		t.Fatalf("expected object format %s and received %s", SHA256, format)
+	}
+
+	// The latest commit and its tree are loose objects
+	obj, err := repo.Object(mustSHA("5e47833487fbadbc14672de6c7e4b79e0f79a3bfa51c8d4307b9efaba5fdee54"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, ok := obj.(Commit)
+	if !ok {
+		t.Fatalf("expected a commit and received %T", obj)
+	}
+	expectedParents := []SHA{mustSHA("8273ecd9022e1a2e5ac01d84bc1309155f242de85f0bf703e16718b31dea5f25")}
+	if commit.Tree != mustSHA("9ffdf4de127488e566ce21e2537e7a3e4e8d4eeb64f7323bef9703274863b6b2") || !reflect.DeepEqual(commit.Parents, expectedParents) {
+		t.Errorf("unexpected tree or parents: %s %v", commit.Tree, commit.Parents)
+	}
+
+	// The previous commit's tree is packed
+	obj, err = repo.Object(commit.Parents[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err = repo.Object(obj.(Commit).Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedEntries := []TreeEntry{
+		{"LICENSE", ModeSymlink, mustSHA("d7f8d669bd27f111f0a2d7883c6352ef80606e7dfcd50dfcffc9f389bf028fbd")},
+		{"docs", ModeTree, mustSHA("cad9bfd5d72133de89d7d859ae54b6918ffb9281fe647517e2fb4f5e8ec64c90")},
+		{"run.sh", ModeExecutable, mustSHA("1249034e3cf9007362d695b09b1fbdb4c578903bf10b665749b94743f8177ce1")},
+		{"zlib.c", ModeRegular, mustSHA("a3762e9864812c5f79e9270ed3a3cd9a66869aed38f3709de083f8f027e7e00d")},
+	}
+	if tree := obj.(Tree); !reflect.DeepEqual(tree.Entries, expectedEntries) {
+		t.Errorf("expected entries %v and received %v", expectedEntries, tree.Entries)
+	}
+
+	// This blob is stored as a delta against the later version of zlib.c
+	obj, err = repo.Object(mustSHA("f7697d7c6e4691a3104284848fdf866efb7199aac76d1bbaa8409c591f04066e"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile(filepath.Join("test_data", "zlib.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(obj.(Blob).Contents, expected) {
+		t.Errorf("delta-encoded blob does not match zlib.c")
+	}
+
+	obj, err = repo.Object(mustSHA("55cd89f2c1f6d679c7fa56ace1e4e90282fafe872b145f0762607e49583c46a3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peeled, err := repo.PeelTag(obj.(Tag))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peeled.Name != commit.Parents[0] {
+		t.Errorf("expected tag to point to %s and received %s", commit.Parents[0], peeled.Name)
+	}
+
+	name, err := repo.ResolvePrefix("5e478334")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != commit.Name {
+		t.Errorf("expected %s and received %s", commit.Name, name)
+	}
+}
+
+func Test_SHA256WriteObject(t *testing.T) {
+	dir, repo := tempRepo(t)
+	defer os.RemoveAll(dir)
+	err := ioutil.WriteFile(filepath.Join(dir, ".git", "config"), []byte("[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectFormat = sha256\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := mustSHA("2cf8d83d9ee29543b34a87727421fdecb7e3f3a183d337639025de576db9ebb4")
+	name, err := repo.WriteObject("blob", strings.NewReader("hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != expected {
+		t.Errorf("expected %s and received %s", expected, name)
+	}
+	if name, _ := SHA256.HashObject("blob", strings.NewReader("hello\n")); name != expected {
+		t.Errorf("expected %s and received %s", expected, name)
+	}
+
+	b := NewTreeBuilder(repo, nil)
+	if err := b.Insert("docs/README", ModeRegular, name); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := repo.Object(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := obj.(Tree).Entries[0]
+	obj, err = docs.Object(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := obj.(Tree).Entries; len(entries) != 1 || entries[0].Hash != expected {
+		t.Errorf("unexpected entries in subtree: %v", entries)
+	}
+}
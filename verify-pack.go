@@ -1,11 +1,16 @@
 package gitgo
 
 import (
+	"bytes"
 	"compress/zlib"
+	"encoding/hex"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"os"
-	"reflect"
+	"sort"
+	"strings"
 )
 
 type errReadSeeker struct {
@@ -29,11 +34,35 @@ func (er *errReadSeeker) Seek(offset int64, whence int) (int64, error) {
 }
 
 // VerifyPack returns the pack objects contained in the packfile and
-// corresponding index file.
+// corresponding index file, which must belong to a SHA-1 repository.
+// Along with reading every object, it checks the CRC32 of each compressed object,
+// the checksum at the end of the packfile, and both checksums at the end of the index.
+// If any of these checks fail, or any object cannot be read, it returns the objects
+// along with a *PackVerifyError that lists every failure.
+//
+// Since the contents of every object are returned, they are all kept in memory,
+// along with each object's delta; unlike Repository.Object, VerifyPack does not
+// use a delta base cache, and needs as much memory as the unpacked objects.
 func VerifyPack(pack io.ReadSeeker, idx io.Reader) ([]*packObject, error) {
+	return verifyPack(pack, idx, SHA1)
+}
+
+// verifyPack is like VerifyPack, but reads a packfile whose objects are named using the given format
+func verifyPack(pack io.ReadSeeker, idx io.Reader, format ObjectFormat) ([]*packObject, error) {
+	data, err := ioutil.ReadAll(idx)
+	if err != nil {
+		return nil, err
+	}
+	index, err := parsePackIndex(data, format)
+	if err != nil {
+		return nil, err
+	}
 
 	objectsMap := map[SHA]*packObject{}
-	objects, err := parsePack(errReadSeeker{pack, nil}, idx)
+	objects, err := parsePack(errReadSeeker{pack, nil}, index, format)
+	if err != nil {
+		return objects, err
+	}
 	for _, object := range objects {
 		objectsMap[object.Name] = object
 	}
@@ -61,13 +90,188 @@ func VerifyPack(pack io.ReadSeeker, idx io.Reader) ([]*packObject, error) {
 	}
 
 	for _, object := range objectsMap {
+		if object.err == nil {
+			object.err = object.Patch(objectsMap)
+		}
+	}
+
+	failures, err := checkPackfile(pack, index, objects)
+	if err != nil {
+		return objects, err
+	}
+	failures = append(failures, checkIndex(data, index)...)
+	for _, object := range objects {
+		if object.err != nil {
+			failures = append(failures, PackFailure{Check: CheckObject, Name: object.Name, Offset: int64(object.Offset), Err: object.err})
+		}
+	}
 
-		object.err = object.Patch(objectsMap)
+	if len(failures) > 0 {
+		return objects, &PackVerifyError{Failures: failures}
+	}
+	return objects, nil
+}
+
+// PackCheck identifies one of the checks performed by VerifyPack
+type PackCheck string
+
+const (
+	// CheckObject fails if an object cannot be read, or its delta cannot be resolved
+	CheckObject PackCheck = "object"
+
+	// CheckCRC32 fails if the compressed object does not match the CRC32 in the index.
+	// Version 1 indexes do not contain CRC32s, so this check is skipped for them.
+	CheckCRC32 PackCheck = "crc32"
+
+	// CheckPackChecksum fails if the checksum at the end of the packfile
+	// does not match its contents
+	CheckPackChecksum PackCheck = "pack checksum"
+
+	// CheckIndexPackChecksum fails if the index was written for a different packfile:
+	// the packfile checksum that it records does not match the packfile's own checksum
+	CheckIndexPackChecksum PackCheck = "index pack checksum"
+
+	// CheckIndexChecksum fails if the checksum at the end of the index
+	// does not match its contents
+	CheckIndexChecksum PackCheck = "index checksum"
+)
+
+// A PackFailure is a single failed check.
+// Expected and Actual hold the checksums that were compared, in hexadecimal.
+type PackFailure struct {
+	Check PackCheck
+
+	// Name and Offset identify the object for CheckObject and CheckCRC32 failures.
+	// For the other checks, Offset is -1.
+	Name   SHA
+	Offset int64
+
+	Expected string
+	Actual   string
+	Err      error
+}
+
+func (f PackFailure) Error() string {
+	var prefix string
+	if f.Offset >= 0 {
+		prefix = fmt.Sprintf("object %s at offset %d: ", f.Name, f.Offset)
+	}
+	if f.Err != nil {
+		return fmt.Sprintf("%s%s: %s", prefix, f.Check, f.Err)
+	}
+	return fmt.Sprintf("%s%s mismatch: expected %s and found %s", prefix, f.Check, f.Expected, f.Actual)
+}
+
+// PackVerifyError is returned by VerifyPack, and lists every check that failed
+type PackVerifyError struct {
+	Failures []PackFailure
+}
+
+func (e *PackVerifyError) Error() string {
+	failures := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		failures[i] = f.Error()
+	}
+	return fmt.Sprintf("packfile verification failed: %s", strings.Join(failures, "; "))
+}
+
+// checkIndex compares the checksum at the end of the index to its contents
+func checkIndex(data []byte, index *packIndex) []PackFailure {
+	h := index.format.New()
+	h.Write(data[:len(data)-index.format.Size()])
+	if actual := h.Sum(nil); !bytes.Equal(actual, index.checksum()) {
+		return []PackFailure{{Check: CheckIndexChecksum, Offset: -1, Expected: hex.EncodeToString(index.checksum()), Actual: hex.EncodeToString(actual)}}
+	}
+	return nil
+}
+
+// checksums compares the checksums of the packfile and its index, and the CRC32
+// of each entry, without inflating any objects
+func (p *packfile) checksums() ([]PackFailure, error) {
+	data, err := ioutil.ReadFile(p.path(".idx"))
+	if err != nil {
+		return nil, err
+	}
+	objects, err := p.idx.objects()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p.path(".pack"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	failures, err := checkPackfile(f, p.idx, objects)
+	if err != nil {
+		return nil, err
+	}
+	return append(failures, checkIndex(data, p.idx)...), nil
+}
+
+// checkPackfile reads the entire packfile in order, computing its checksum along
+// with the CRC32 of each compressed object, and compares them to the index
+func checkPackfile(pack io.ReadSeeker, index *packIndex, objects []*packObject) ([]PackFailure, error) {
+	hashSize := index.format.Size()
+	size, err := pack.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size < 12+int64(hashSize) {
+		return nil, fmt.Errorf("packfile is too short: %d bytes", size)
+	}
+	if _, err = pack.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var failures []PackFailure
+	positions := make([]int, index.count)
+	for i := range positions {
+		positions[i] = i
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return objects[positions[i]].Offset < objects[positions[j]].Offset
+	})
+
+	h := index.format.New()
+	var pos int64
+	for n, i := range positions {
+		object := objects[i]
+		// Everything up to the first object is the packfile header
+		if _, err := io.CopyN(h, pack, int64(object.Offset)-pos); err != nil {
+			return nil, err
+		}
+		end := size - int64(hashSize)
+		if n+1 < len(positions) {
+			end = int64(objects[positions[n+1]].Offset)
+		}
+		crc := crc32.NewIEEE()
+		if _, err := io.CopyN(io.MultiWriter(h, crc), pack, end-int64(object.Offset)); err != nil {
+			return nil, err
+		}
+		pos = end
+
+		if expected, ok := index.crc32(i); ok && expected != crc.Sum32() {
+			failures = append(failures, PackFailure{Check: CheckCRC32, Name: object.Name, Offset: int64(object.Offset), Expected: fmt.Sprintf("%08x", expected), Actual: fmt.Sprintf("%08x", crc.Sum32())})
+		}
+	}
+	if _, err := io.CopyN(h, pack, size-int64(hashSize)-pos); err != nil {
+		return nil, err
+	}
+
+	trailer := make([]byte, hashSize)
+	if _, err := io.ReadFull(pack, trailer); err != nil {
+		return nil, err
+	}
+	if actual := h.Sum(nil); !bytes.Equal(actual, trailer) {
+		failures = append(failures, PackFailure{Check: CheckPackChecksum, Offset: -1, Expected: hex.EncodeToString(trailer), Actual: hex.EncodeToString(actual)})
+	}
+	if !bytes.Equal(index.packChecksum(), trailer) {
+		failures = append(failures, PackFailure{Check: CheckIndexPackChecksum, Offset: -1, Expected: hex.EncodeToString(index.packChecksum()), Actual: hex.EncodeToString(trailer)})
 	}
-	return objects, err
+	return failures, nil
 }
 
-func parsePack(pack errReadSeeker, idx io.Reader) (objects []*packObject, err error) {
+func parsePack(pack errReadSeeker, index *packIndex, format ObjectFormat) (objects []*packObject, err error) {
 	signature := make([]byte, 4)
 	pack.read(signature)
 	if string(signature) != "PACK" {
@@ -82,13 +286,14 @@ func parsePack(pack errReadSeeker, idx io.Reader) (objects []*packObject, err er
 	// TODO use encoding/binary here
 	v := version[3]
 	switch v {
-	case 2:
-		// Parse version 2 packfile
-		objects, err = parseIdx(idx, 2)
+	case 2, 3:
+		// Parse version 2 packfile.
+		// git can write version 3 as well, which has the same format.
+		objects, err = index.objects()
 		if err != nil {
 			return
 		}
-		objects, err = parsePackV2(pack, objects)
+		objects, err = parsePackV2(pack, objects, format)
 		return
 
 	default:
@@ -106,7 +311,7 @@ func bytesToNum(b []byte) uint {
 
 // parsePackV2 parses a packfile that uses
 // version 2 of the format
-func parsePackV2(r errReadSeeker, objects []*packObject) ([]*packObject, error) {
+func parsePackV2(r errReadSeeker, objects []*packObject, format ObjectFormat) ([]*packObject, error) {
 
 	numObjectsBts := make([]byte, 4)
 	r.read(numObjectsBts)
@@ -116,263 +321,144 @@ func parsePackV2(r errReadSeeker, objects []*packObject) ([]*packObject, error)
 
 	for _, object := range objects {
 
-		var btsread int
 		r.Seek(int64(object.Offset), os.SEEK_SET)
-		_bytes := make([]byte, 1)
-		btsread += r.read(_bytes)
-		_byte := _bytes[0]
-
-		// This will extract the last three bits of
-		// the first nibble in the byte
-		// which tells us the object type
-		object._type = packObjectType(((_byte >> 4) & 7))
-
-		// determine the (decompressed) object size
-		// and then deflate the following bytes
-
-		// The most-significant byte (MSB)
-		// tells us whether we need to read more bytes
-		// to get the encoded object size
-		MSB := (_byte & 128) // will be either 128 or 0
-
-		// This will extract the last four bits of the byte
-		var objectSize = int((uint(_byte) & 15))
-
-		// shift the first size by 0
-		// and the rest by 4 + (i-1) * 7
-		var shift uint = 4
-
-		// If the most-significant bit is 0, this is the last byte
-		// for the object size
-		for MSB > 0 {
-			// Keep reading the size until the MSB is 0
-			_bytes := make([]byte, 1)
-			btsread += r.read(_bytes)
-			_byte := _bytes[0]
-
-			MSB = (_byte & 128)
-
-			objectSize += int((uint(_byte) & 127) << shift)
-			shift += 7
+		_type, objectSize, err := readPackObjectHeader(r.r)
+		if err != nil {
+			// Keep going, so that the rest of the packfile is still checked
+			object.err = err
+			continue
 		}
+		object._type = _type
 
 		object.Size = objectSize
-		switch {
-		case object._type < 5:
-			// the object is a commit, tree, blob, or tag
-
+		switch object._type {
+		case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
 			// (objectSize) is the size, in bytes, of this object *when expanded*
 			// the IDX file tells us how many *compressed* bytes the object will take
 			// (in other words, how much space to allocate for the result)
-			object.Data = make([]byte, objectSize)
-
-			zr, err := zlib.NewReader(r.r)
-			if err != nil {
-				return nil, err
-			}
-
-			n, err := zr.Read(object.Data)
-			if err != nil {
-				if err == io.EOF {
-					err = nil
-				} else {
-					return nil, err
-				}
-			}
-			zr.Close()
-			object.Data = object.Data[:n]
-
-			// TODO figure out why sometimes n < objectSize
+			object.Data, object.err = inflate(r.r, objectSize)
 
-		case object._type == OBJ_OFS_DELTA:
-			// read the n-byte offset
-			// from the git docs:
-			// "n bytes with MSB set in all but the last one.
-			// The offset is then the number constructed by
-			// concatenating the lower 7 bit of each byte, and
-			// for n >= 2 adding 2^7 + 2^14 + ... + 2^(7*(n-1))
-			// to the result."
-
-			var offset int
-
-			// number of bytes read in variable length encoding
-			var nbytes uint
-
-			MSB := 128
-			for (MSB & 128) > 0 {
-				nbytes++
-
-				// Keep reading the size until the MSB is 0
-				_bytes := make([]byte, 1)
-				r.read(_bytes)
-				_byte := _bytes[0]
-
-				sevenBytes := uint(_byte) & 127
-
-				offset = (offset << 7) + int(sevenBytes)
-
-				MSB = int(_byte & 128)
-				if MSB == 0 {
-					break
-				}
-			}
-
-			if nbytes >= 2 {
-				offset += (1 << (7 * (nbytes - 1)))
-			}
-
-			object.negativeOffset = offset
-			object.baseOffset = object.Offset - object.negativeOffset
-			object.Data = make([]byte, objectSize)
-
-			zr, err := zlib.NewReader(r.r)
+		case OBJ_OFS_DELTA:
+			offset, err := readOffsetDelta(r.r)
 			if err != nil {
 				object.err = err
 				continue
 			}
-			n, err := zr.Read(object.Data)
-			if err != nil && err != io.EOF {
-				object.err = err
-				continue
-			}
-			object.Data = object.Data[:n]
-			zr.Close()
-			if len(object.Data) != objectSize {
-				object.err = fmt.Errorf("received wrong object size: %d (expected %d)", object.Data, objectSize)
-			}
-
-		case object._type == OBJ_REF_DELTA:
-			r.Seek(int64(object.Offset), os.SEEK_SET)
-			// Read the 20-byte base object name
-			baseObjName := make([]byte, 20)
 
-			r.read(baseObjName)
-			object.Data = make([]byte, objectSize)
+			object.negativeOffset = int(offset)
+			object.baseOffset = object.Offset - object.negativeOffset
+			object.Data, object.err = inflate(r.r, objectSize)
 
-			zr, err := zlib.NewReader(r.r)
-			if err != nil {
-				object.err = err
-				continue
-			}
-			n, err := zr.Read(object.Data)
-			if err != nil && err != io.EOF {
+		case OBJ_REF_DELTA:
+			// The base object name (20 bytes, or 32 for SHA-256)
+			// follows the header
+			baseObjName := make([]byte, format.Size())
+			if _, err := io.ReadFull(r.r, baseObjName); err != nil {
 				object.err = err
 				continue
 			}
-			object.Data = object.Data[:n]
-			zr.Close()
+			object.BaseObjectName = newSHA(baseObjName)
+			object.Data, object.err = inflate(r.r, objectSize)
+
+		default:
+			object.err = fmt.Errorf("invalid object type %d", object._type)
 		}
 	}
 
 	return objects, r.err
 }
 
-func parseIdx(idx io.Reader, version int) (objects []*packObject, err error) {
-	if version != 2 {
-		return nil, fmt.Errorf("cannot parse IDX with version %d", version)
+// inflate decompresses a zlib stream that is expected
+// to expand to exactly size bytes. The size comes from the packfile,
+// so the contents are read through a limit rather than allocated up front.
+func inflate(r io.Reader, size int) ([]byte, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid object size %d", size)
 	}
-	// parse version 2 idxfile
-
-	// Version 2 starts with a 4-byte magic number
-	header := make([]byte, 4)
-	n, err := idx.Read(header)
+	zr, err := zlib.NewReader(r)
 	if err != nil {
 		return nil, err
 	}
+	defer zr.Close()
 
-	if !reflect.DeepEqual([]byte{255, 116, 79, 99}, header) {
-		return nil, fmt.Errorf("invalid IDX header: %q", string(header))
-	}
-
-	// Then the version number in four bytes
-	versionBts := make([]byte, 4)
-	_, err = idx.Read(versionBts)
+	data, err := ioutil.ReadAll(io.LimitReader(zr, int64(size)+1))
 	if err != nil {
 		return nil, err
 	}
-	// We already know the version, so we can ignore it
-
-	// Then the fanout table
-	// The fanout table has 256 entries, each 4 bytes long
-	fanoutTableFlat := make([]byte, 256*4)
-	n, err = idx.Read(fanoutTableFlat)
-	if err == nil && n != len(fanoutTableFlat) {
-		err = fmt.Errorf("read incomplete fanout table: %d", n)
-	}
-	if err != nil {
-		return nil, err
+	if len(data) != size {
+		return nil, fmt.Errorf("object does not match its declared size of %d bytes", size)
 	}
+	return data, nil
+}
 
-	// Initialize the flat fanout table
-	fanoutTable := make([][]byte, 256)
-	for i := 0; i < len(fanoutTableFlat); i += 4 {
-		entry := fanoutTableFlat[i : i+4]
-		fanoutTable[(i+1)/4] = entry
+// readPackObjectHeader reads the type and the (decompressed) size
+// from the start of a packfile entry
+func readPackObjectHeader(r io.Reader) (packObjectType, int, error) {
+	_bytes := make([]byte, 1)
+	if _, err := io.ReadFull(r, _bytes); err != nil {
+		return 0, 0, err
 	}
-
-	numObjects := int(bytesToNum(fanoutTable[len(fanoutTable)-1]))
-	objects = make([]*packObject, numObjects)
-
-	objectNames := make([]SHA, numObjects)
-
-	for i := 0; i < numObjects; i++ {
-		sha := make([]byte, 20)
-		n, err = idx.Read(sha)
-		if err != nil {
-			return nil, err
+	_byte := _bytes[0]
+
+	// This will extract the last three bits of
+	// the first nibble in the byte
+	// which tells us the object type
+	_type := packObjectType(((_byte >> 4) & 7))
+
+	// The most-significant byte (MSB)
+	// tells us whether we need to read more bytes
+	// to get the encoded object size
+	MSB := (_byte & 128) // will be either 128 or 0
+
+	// This will extract the last four bits of the byte
+	var objectSize = int((uint(_byte) & 15))
+
+	// shift the first size by 0
+	// and the rest by 4 + (i-1) * 7
+	var shift uint = 4
+
+	// If the most-significant bit is 0, this is the last byte
+	// for the object size
+	for MSB > 0 {
+		// Keep reading the size until the MSB is 0
+		if _, err := io.ReadFull(r, _bytes); err != nil {
+			return 0, 0, err
 		}
+		_byte := _bytes[0]
 
-		objectNames[i] = SHA(fmt.Sprintf("%x", sha[:n]))
-		objects[i] = &packObject{Name: SHA(fmt.Sprintf("%x", sha[:n]))}
-	}
-
-	// Then come 4-byte CRC32 values
-	crc32Table := make([]byte, numObjects*4)
-	_, err = idx.Read(crc32Table)
-	if err != nil {
-		return nil, err
-	}
-
-	// Next come 4-byte offset values
-	// If the MSB is set, there is an index into the next table
-	// otherwise, these are 31 bits each
-	offsetsFlat := make([]byte, numObjects*4)
-	_, err = idx.Read(offsetsFlat)
-	if err != nil {
-		return nil, err
-	}
+		MSB = (_byte & 128)
 
-	offsets := make([]int, numObjects)
-	for i := 0; i < len(offsets); i++ {
-		offset := int(bytesToNum(offsetsFlat[i*4 : (i+1)*4]))
-		// check if the MSB is 1
-		if offset&2147483648 > 0 {
-			return nil, fmt.Errorf("packfile is too large to parse")
+		if shift > 63-7 || (uint64(_byte)&127)<<shift > uint64(maxInt-objectSize) {
+			return 0, 0, fmt.Errorf("object size is too large")
 		}
-		offsets[i] = offset
-		objects[i].Offset = offset
+		objectSize += int((uint(_byte) & 127) << shift)
+		shift += 7
 	}
+	return _type, objectSize, nil
+}
 
-	// If the pack file is more than 2 GB, there will be a table of 8-byte offset entries here
-	// TODO implement this
-
-	// This is the same as the checksum at the end of the corresponding packfile
-	packfileChecksum := make([]byte, 20)
-	_, err = idx.Read(packfileChecksum)
-	if err != nil {
-		return
+// readOffsetDelta reads the distance from an OBJ_OFS_DELTA entry back to its base.
+// From the git docs:
+// "n bytes with MSB set in all but the last one.
+// The offset is then the number constructed by
+// concatenating the lower 7 bit of each byte, and
+// for n >= 2 adding 2^7 + 2^14 + ... + 2^(7*(n-1))
+// to the result."
+// Adding one before each shift accounts for all of those terms.
+func readOffsetDelta(r io.Reader) (int64, error) {
+	_bytes := make([]byte, 1)
+	if _, err := io.ReadFull(r, _bytes); err != nil {
+		return 0, err
 	}
-
-	// This is the checksum of all of the above data
-	// We're not checking it now, but if we can't read it properly
-	// that means an error has occurred earlier in parsing
-	idxChecksum := make([]byte, 20)
-	_, err = idx.Read(idxChecksum)
-	if err != nil {
-		return
+	offset := int64(_bytes[0] & 127)
+	for _bytes[0]&128 > 0 {
+		if offset >= 1<<(63-7)-1 {
+			return 0, fmt.Errorf("delta base offset overflows")
+		}
+		if _, err := io.ReadFull(r, _bytes); err != nil {
+			return 0, err
+		}
+		offset = ((offset + 1) << 7) | int64(_bytes[0]&127)
 	}
-
-	// TODO check that there isn't any data left
-
-	return objects, err
+	return offset, nil
 }
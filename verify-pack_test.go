@@ -1,10 +1,22 @@
 package gitgo
 
 import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"testing"
 )
 
@@ -60,23 +72,23 @@ chain length = 2: 1 object
 		BaseObjectName SHA
 	}
 	objs := map[string]packObjectMock{
-		"fe89ee30bbcdfdf376beae530cc53f967012f31c": packObjectMock{Name: SHA("fe89ee30bbcdfdf376beae530cc53f967012f31c"), _type: 1, Type: "commit", Size: 267, SizeInPackfile: 184, Offset: 12},
-		"3ead3116d0378089f5ce61086354aac43e736b01": packObjectMock{Name: SHA("3ead3116d0378089f5ce61086354aac43e736b01"), _type: 1, Type: "commit", Size: 243, SizeInPackfile: 170, Offset: 196},
-		"1d833eb5b6c5369c0cb7a4a3e20ded237490145f": packObjectMock{Name: SHA("1d833eb5b6c5369c0cb7a4a3e20ded237490145f"), _type: 1, Type: "commit", Size: 262, SizeInPackfile: 180, Offset: 366},
-		"a7f92c920ce85f07a33f948aa4fa2548b270024f": packObjectMock{Name: SHA("a7f92c920ce85f07a33f948aa4fa2548b270024f"), _type: 1, Type: "commit", Size: 250, SizeInPackfile: 172, Offset: 546},
-		"97eed02ebe122df8fdd853c1215d8775f3d9f1a1": packObjectMock{Name: SHA("97eed02ebe122df8fdd853c1215d8775f3d9f1a1"), _type: 1, Type: "commit", Size: 190, SizeInPackfile: 132, Offset: 718},
-		"d22fc8a57073fdecae2001d00aff921440d3aabd": packObjectMock{Name: SHA("d22fc8a57073fdecae2001d00aff921440d3aabd"), _type: 2, Type: "tree", Size: 121, SizeInPackfile: 115, Offset: 850},
-		"df891299372c34b57e41cfc50a0113e2afac3210": packObjectMock{Name: SHA("df891299372c34b57e41cfc50a0113e2afac3210"), _type: 2, Type: "tree", Size: 25, SizeInPackfile: 37, Offset: 965, Depth: 1, BaseObjectName: "d22fc8a57073fdecae2001d00aff921440d3aabd"},
-		"af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67": packObjectMock{Name: SHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"), _type: 3, Type: "blob", Size: 18, SizeInPackfile: 23, Offset: 1002},
-		"6b32b1ac731898894c403f6b621bdda167ab8d7c": packObjectMock{Name: SHA("6b32b1ac731898894c403f6b621bdda167ab8d7c"), _type: 3, Type: "blob", Size: 1645, SizeInPackfile: 700, Offset: 1025},
-		"7147f43ae01c9f04a78d6e80544ed84def06e958": packObjectMock{Name: SHA("7147f43ae01c9f04a78d6e80544ed84def06e958"), _type: 3, Type: "blob", Size: 1824, SizeInPackfile: 697, Offset: 1725},
-		"05d3cc770bd3524cc25d47e083d8942ad25033f0": packObjectMock{Name: SHA("05d3cc770bd3524cc25d47e083d8942ad25033f0"), _type: 3, Type: "blob", Size: 16, SizeInPackfile: 28, Offset: 2422, Depth: 1, BaseObjectName: "7147f43ae01c9f04a78d6e80544ed84def06e958"},
-		"c3b8133617bbdb72e237b0f163fade7fbf1f0c18": packObjectMock{Name: SHA("c3b8133617bbdb72e237b0f163fade7fbf1f0c18"), _type: 3, Type: "blob", Size: 381, SizeInPackfile: 317, Offset: 2450, Depth: 2, BaseObjectName: "05d3cc770bd3524cc25d47e083d8942ad25033f0"},
-		"8264d7bcc297e15c452a7aef3a2e40934762b7e3": packObjectMock{Name: SHA("8264d7bcc297e15c452a7aef3a2e40934762b7e3"), _type: 2, Type: "tree", Size: 25, SizeInPackfile: 38, Offset: 2767, Depth: 1, BaseObjectName: "d22fc8a57073fdecae2001d00aff921440d3aabd"},
-		"254671773e8cd91e07e36546c9a2d9c27e8dfeec": packObjectMock{Name: SHA("254671773e8cd91e07e36546c9a2d9c27e8dfeec"), _type: 2, Type: "tree", Size: 121, SizeInPackfile: 115, Offset: 2805},
-		"ba74813270ff557c4a5d1be0562a141bbee4d3e6": packObjectMock{Name: SHA("ba74813270ff557c4a5d1be0562a141bbee4d3e6"), _type: 3, Type: "blob", Size: 16, SizeInPackfile: 28, Offset: 2920, Depth: 1, BaseObjectName: "6b32b1ac731898894c403f6b621bdda167ab8d7c"},
-		"b45377f6daf59a4cec9e8de64f5df1533a7994cd": packObjectMock{Name: SHA("b45377f6daf59a4cec9e8de64f5df1533a7994cd"), _type: 3, Type: "blob", Size: 10, SizeInPackfile: 21, Offset: 2948, Depth: 1, BaseObjectName: "7147f43ae01c9f04a78d6e80544ed84def06e958"},
-		"9de6c72106b169990a83ce7090c7cad84b6b506b": packObjectMock{Name: SHA("9de6c72106b169990a83ce7090c7cad84b6b506b"), _type: 2, Type: "tree", Size: 38, SizeInPackfile: 49, Offset: 2969},
+		"fe89ee30bbcdfdf376beae530cc53f967012f31c": packObjectMock{Name: mustSHA("fe89ee30bbcdfdf376beae530cc53f967012f31c"), _type: 1, Type: "commit", Size: 267, SizeInPackfile: 184, Offset: 12},
+		"3ead3116d0378089f5ce61086354aac43e736b01": packObjectMock{Name: mustSHA("3ead3116d0378089f5ce61086354aac43e736b01"), _type: 1, Type: "commit", Size: 243, SizeInPackfile: 170, Offset: 196},
+		"1d833eb5b6c5369c0cb7a4a3e20ded237490145f": packObjectMock{Name: mustSHA("1d833eb5b6c5369c0cb7a4a3e20ded237490145f"), _type: 1, Type: "commit", Size: 262, SizeInPackfile: 180, Offset: 366},
+		"a7f92c920ce85f07a33f948aa4fa2548b270024f": packObjectMock{Name: mustSHA("a7f92c920ce85f07a33f948aa4fa2548b270024f"), _type: 1, Type: "commit", Size: 250, SizeInPackfile: 172, Offset: 546},
+		"97eed02ebe122df8fdd853c1215d8775f3d9f1a1": packObjectMock{Name: mustSHA("97eed02ebe122df8fdd853c1215d8775f3d9f1a1"), _type: 1, Type: "commit", Size: 190, SizeInPackfile: 132, Offset: 718},
+		"d22fc8a57073fdecae2001d00aff921440d3aabd": packObjectMock{Name: mustSHA("d22fc8a57073fdecae2001d00aff921440d3aabd"), _type: 2, Type: "tree", Size: 121, SizeInPackfile: 115, Offset: 850},
+		"df891299372c34b57e41cfc50a0113e2afac3210": packObjectMock{Name: mustSHA("df891299372c34b57e41cfc50a0113e2afac3210"), _type: 2, Type: "tree", Size: 25, SizeInPackfile: 37, Offset: 965, Depth: 1, BaseObjectName: mustSHA("d22fc8a57073fdecae2001d00aff921440d3aabd")},
+		"af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67": packObjectMock{Name: mustSHA("af6e4fe91a8f9a0f3c03cbec9e1d2aac47345d67"), _type: 3, Type: "blob", Size: 18, SizeInPackfile: 23, Offset: 1002},
+		"6b32b1ac731898894c403f6b621bdda167ab8d7c": packObjectMock{Name: mustSHA("6b32b1ac731898894c403f6b621bdda167ab8d7c"), _type: 3, Type: "blob", Size: 1645, SizeInPackfile: 700, Offset: 1025},
+		"7147f43ae01c9f04a78d6e80544ed84def06e958": packObjectMock{Name: mustSHA("7147f43ae01c9f04a78d6e80544ed84def06e958"), _type: 3, Type: "blob", Size: 1824, SizeInPackfile: 697, Offset: 1725},
+		"05d3cc770bd3524cc25d47e083d8942ad25033f0": packObjectMock{Name: mustSHA("05d3cc770bd3524cc25d47e083d8942ad25033f0"), _type: 3, Type: "blob", Size: 16, SizeInPackfile: 28, Offset: 2422, Depth: 1, BaseObjectName: mustSHA("7147f43ae01c9f04a78d6e80544ed84def06e958")},
+		"c3b8133617bbdb72e237b0f163fade7fbf1f0c18": packObjectMock{Name: mustSHA("c3b8133617bbdb72e237b0f163fade7fbf1f0c18"), _type: 3, Type: "blob", Size: 381, SizeInPackfile: 317, Offset: 2450, Depth: 2, BaseObjectName: mustSHA("05d3cc770bd3524cc25d47e083d8942ad25033f0")},
+		"8264d7bcc297e15c452a7aef3a2e40934762b7e3": packObjectMock{Name: mustSHA("8264d7bcc297e15c452a7aef3a2e40934762b7e3"), _type: 2, Type: "tree", Size: 25, SizeInPackfile: 38, Offset: 2767, Depth: 1, BaseObjectName: mustSHA("d22fc8a57073fdecae2001d00aff921440d3aabd")},
+		"254671773e8cd91e07e36546c9a2d9c27e8dfeec": packObjectMock{Name: mustSHA("254671773e8cd91e07e36546c9a2d9c27e8dfeec"), _type: 2, Type: "tree", Size: 121, SizeInPackfile: 115, Offset: 2805},
+		"ba74813270ff557c4a5d1be0562a141bbee4d3e6": packObjectMock{Name: mustSHA("ba74813270ff557c4a5d1be0562a141bbee4d3e6"), _type: 3, Type: "blob", Size: 16, SizeInPackfile: 28, Offset: 2920, Depth: 1, BaseObjectName: mustSHA("6b32b1ac731898894c403f6b621bdda167ab8d7c")},
+		"b45377f6daf59a4cec9e8de64f5df1533a7994cd": packObjectMock{Name: mustSHA("b45377f6daf59a4cec9e8de64f5df1533a7994cd"), _type: 3, Type: "blob", Size: 10, SizeInPackfile: 21, Offset: 2948, Depth: 1, BaseObjectName: mustSHA("7147f43ae01c9f04a78d6e80544ed84def06e958")},
+		"9de6c72106b169990a83ce7090c7cad84b6b506b": packObjectMock{Name: mustSHA("9de6c72106b169990a83ce7090c7cad84b6b506b"), _type: 2, Type: "tree", Size: 38, SizeInPackfile: 49, Offset: 2969},
 	}
 
 	objects, err := VerifyPack(packFile, idxFile)
@@ -89,7 +101,7 @@ chain length = 2: 1 object
 	}
 
 	for _, object := range objects {
-		expectedObj, ok := objs[string(object.Name)]
+		expectedObj, ok := objs[object.Name.String()]
 		if !ok {
 			t.Errorf("encountered incorrect hash %s", object.Name)
 		}
@@ -130,6 +142,55 @@ chain length = 2: 1 object
 	*/
 }
 
+func Test_VerifyPackMemory(t *testing.T) {
+	// Twenty versions of a 256 KiB blob, each stored as a delta of the next
+	dir, repo := tempRepo(t)
+	defer os.RemoveAll(dir)
+	contents := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(contents)
+	w := NewPackWriter(repo)
+	for i := 0; i < 20; i++ {
+		contents[i*1000] ^= 0xff
+		name, err := repo.WriteObject("blob", bytes.NewReader(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Add(name)
+	}
+	var pack, idx bytes.Buffer
+	if _, err := w.Write(&pack, &idx); err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	objects, err := VerifyPack(bytes.NewReader(pack.Bytes()), bytes.NewReader(idx.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	// Every object is kept in memory, but nothing else is:
+	// the memory in use is at most twice the contents of the objects and their deltas
+	var size, deltas int
+	for _, object := range objects {
+		size += len(object.PatchedData)
+		if object._type == OBJ_OFS_DELTA {
+			size += len(object.Data)
+			deltas++
+		}
+	}
+	if deltas == 0 {
+		t.Errorf("expected some objects to be stored as deltas")
+	}
+	if used := int64(after.HeapAlloc) - int64(before.HeapAlloc); used > 2*int64(size) {
+		t.Errorf("expected at most %d bytes in use and received %d", 2*size, used)
+	}
+	runtime.KeepAlive(objects)
+}
+
 func BenchmarkVerifyPack(b *testing.B) {
 	packFile, err := os.Open(path.Join(RepoDir.Name(), "objects/pack/pack-d310969c4ba0ebfe725685fa577a1eec5ecb15b2.pack"))
 	if err != nil {
@@ -153,3 +214,148 @@ func BenchmarkVerifyPack(b *testing.B) {
 		idxFile.Seek(0, io.SeekStart)
 	}
 }
+
+func Test_VerifyPackOversized(t *testing.T) {
+	name, err := HashObject("blob", bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	headers := map[string][]byte{
+		// A blob that claims to be 1 TiB
+		"oversized": {0xb0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x02},
+		// A size that does not fit in 64 bits
+		"overflow": {0xb0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+		// A blob that claims to be shorter than it is
+		"short": {0x34},
+	}
+	for desc, header := range headers {
+		var pack bytes.Buffer
+		pack.WriteString("PACK")
+		binary.Write(&pack, binary.BigEndian, []uint32{2, 1})
+		entry := append(append([]byte(nil), header...), compressed.Bytes()...)
+		pack.Write(entry)
+		h := SHA1.New()
+		h.Write(pack.Bytes())
+		checksum := h.Sum(nil)
+		pack.Write(checksum)
+		var idx bytes.Buffer
+		if err := writePackIndex(&idx, SHA1, []indexEntry{{name, 12, crc32.ChecksumIEEE(entry)}}, checksum); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := VerifyPack(bytes.NewReader(pack.Bytes()), bytes.NewReader(idx.Bytes()))
+		var verifyErr *PackVerifyError
+		if !errors.As(err, &verifyErr) || len(verifyErr.Failures) != 1 || verifyErr.Failures[0].Check != CheckObject {
+			t.Errorf("%s: expected an object failure and received %v", desc, err)
+		}
+
+		// The object cannot be read from a repository either
+		dir, repo := tempRepo(t)
+		defer os.RemoveAll(dir)
+		packPath := filepath.Join(repo.Basedir.Name(), "objects", "pack", "pack-"+hex.EncodeToString(checksum))
+		if err := ioutil.WriteFile(packPath+".pack", pack.Bytes(), 0444); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(packPath+".idx", idx.Bytes(), 0444); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Object(name); err == nil {
+			t.Errorf("%s: expected an error reading the object", desc)
+		}
+	}
+}
+
+func Test_readOffsetDelta(t *testing.T) {
+	cases := []struct {
+		input    []byte
+		expected int64
+	}{
+		{[]byte{0x7f}, 127},
+		{[]byte{0x80, 0x00}, 128},
+		{[]byte{0x81, 0x00}, 256},
+		{[]byte{0xff, 0x7f}, 16511},
+		{[]byte{0x80, 0x80, 0x00}, 16512},
+		{[]byte{0x81, 0x80, 0x05}, 32901},
+	}
+	for _, c := range cases {
+		offset, err := readOffsetDelta(bytes.NewReader(c.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != c.expected {
+			t.Errorf("expected %d for %x and received %d", c.expected, c.input, offset)
+		}
+	}
+}
+
+func Test_VerifyPackChecksums(t *testing.T) {
+	const packName = "objects/pack/pack-d310969c4ba0ebfe725685fa577a1eec5ecb15b2"
+	pack, err := ioutil.ReadFile(path.Join(RepoDir.Name(), packName+".pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := ioutil.ReadFile(path.Join(RepoDir.Name(), packName+".idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := func(err error) map[PackCheck][]int64 {
+		var verifyErr *PackVerifyError
+		if !errors.As(err, &verifyErr) {
+			t.Fatalf("expected a *PackVerifyError and received %v", err)
+		}
+		result := map[PackCheck][]int64{}
+		for _, f := range verifyErr.Failures {
+			result[f.Check] = append(result[f.Check], f.Offset)
+		}
+		return result
+	}
+
+	// Change the compression level recorded in the zlib header of af6e4fe,
+	// which is stored at offset 1002 after a 2-byte header, from 0x9c to 0xda.
+	// The header is still valid and the object is still read correctly,
+	// so only the CRC32 catches it.
+	corruptPack := append([]byte(nil), pack...)
+	corruptPack[1002+3] = 0xda
+	_, err = VerifyPack(bytes.NewReader(corruptPack), bytes.NewReader(idx))
+	expected := map[PackCheck][]int64{
+		CheckCRC32:        {1002},
+		CheckPackChecksum: {-1},
+	}
+	if result := failed(err); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected failures %v and received %v", expected, result)
+	}
+
+	// Corrupt the zlib header of 7147f43, which is stored at offset 1725
+	// after a 2-byte header. The deltas that depend on it fail as well,
+	// but the rest of the packfile is still checked.
+	corruptPack = append([]byte(nil), pack...)
+	corruptPack[1725+2] ^= 0xff
+	_, err = VerifyPack(bytes.NewReader(corruptPack), bytes.NewReader(idx))
+	expected = map[PackCheck][]int64{
+		CheckObject:       {1725, 2422, 2450, 2948},
+		CheckCRC32:        {1725},
+		CheckPackChecksum: {-1},
+	}
+	result := failed(err)
+	sort.Slice(result[CheckObject], func(i, j int) bool { return result[CheckObject][i] < result[CheckObject][j] })
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected failures %v and received %v", expected, result)
+	}
+
+	// Change the packfile checksum recorded in the index
+	corruptIdx := append([]byte(nil), idx...)
+	corruptIdx[len(corruptIdx)-2*SHA1.Size()] ^= 0xff
+	_, err = VerifyPack(bytes.NewReader(pack), bytes.NewReader(corruptIdx))
+	expected = map[PackCheck][]int64{
+		CheckIndexPackChecksum: {-1},
+		CheckIndexChecksum:     {-1},
+	}
+	if result := failed(err); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected failures %v and received %v", expected, result)
+	}
+}